@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/fanout"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/spool"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/stdout"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+)
+
+// newExporters returns the exporter that sends metrics to conf.Exporters.
+// Metrics of queries that have exporters are sent only to them.
+// Servers started by exporters are stopped when ctx is done.
+// It also returns exporters that spool failed metrics; they should be replayed before each run.
+func newExporters(ctx context.Context, conf *option.Config, queries []query.Query, logger logr.Logger) (driver.Exporter, []*spool.Exporter, error) {
+	if len(conf.Exporters) == 0 {
+		return nil, nil, fmt.Errorf("no exporters")
+	}
+	for _, name := range conf.BestEffortExporters {
+		if !slices.Contains(conf.Exporters, name) {
+			return nil, nil, fmt.Errorf("%s: best-effort exporter is not in exporters", name)
+		}
+	}
+	if err := checkRoutes(queries, conf.Exporters); err != nil {
+		return nil, nil, err
+	}
+	var spoolURL *url.URL
+	if conf.Spool != "" {
+		u, err := url.Parse(conf.Spool)
+		if err != nil {
+			return nil, nil, err
+		}
+		spoolURL = u
+	}
+	env := &driver.Env{
+		Interval: conf.Interval,
+		Queries:  queries,
+		Logger:   logger,
+	}
+
+	var (
+		backends []*fanout.Backend
+		spools   []*spool.Exporter
+	)
+	seen := make(map[string]bool)
+	for _, name := range conf.Exporters {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		e, err := exporter.OpenWithContext(ctx, name, conf.ExporterOptions[name], env)
+		if err == nil && spoolURL != nil && slices.Contains(conf.SpoolExporters, name) {
+			var s *spool.Exporter
+			s, err = newSpool(ctx, e, spoolURL.JoinPath(name), conf.SpoolMaxAge, logger.WithValues("exporter", name))
+			if s != nil {
+				spools = append(spools, s)
+				e = s
+			}
+		}
+		if err != nil {
+			fanout.NewExporter(backends, logger).Close() // nolint
+			return nil, nil, err
+		}
+		backends = append(backends, &fanout.Backend{
+			Name:       name,
+			Exporter:   e,
+			BestEffort: slices.Contains(conf.BestEffortExporters, name),
+		})
+	}
+	return fanout.NewExporter(backends, logger), spools, nil
+}
+
+// newSpool wraps e to spool metrics it failed to export into u. e is closed if it failed.
+func newSpool(ctx context.Context, e driver.Exporter, u *url.URL, maxAge time.Duration, logger logr.Logger) (*spool.Exporter, error) {
+	store, err := spool.Open(ctx, u)
+	if err != nil {
+		if c, ok := e.(io.Closer); ok {
+			c.Close() // nolint
+		}
+		return nil, err
+	}
+	return spool.NewExporter(e, store, maxAge, logger), nil
+}
+
+// newPlanWriter opens the stdout exporter to print results of dry runs in its format.
+// Other exporters are not opened because they may fetch secrets or connect to backends.
+func newPlanWriter(ctx context.Context, conf *option.Config, logger logr.Logger) (driver.PlanWriter, error) {
+	e, err := exporter.OpenWithContext(ctx, stdout.Name, conf.ExporterOptions[stdout.Name], &driver.Env{Logger: logger})
+	if err != nil {
+		return nil, err
+	}
+	w, ok := e.(driver.PlanWriter)
+	if !ok {
+		return nil, fmt.Errorf("%s: exporter cannot print results of dry runs", stdout.Name)
+	}
+	return w, nil
+}
+
+// checkRoutes validates exporters of queries.
+// Queries whose exporters are all disabled in this run are errors because their metrics are not sent anywhere.
+func checkRoutes(queries []query.Query, enabled []string) error {
+	registered := exporter.Names()
+	for _, q := range queries {
+		r, ok := q.(query.Router)
+		if !ok || len(r.GetExporters()) == 0 {
+			continue
+		}
+		names := r.GetExporters()
+		for _, name := range names {
+			if !slices.Contains(registered, name) {
+				return fmt.Errorf("query %s: %s: unknown exporter", q.GetName(), name)
+			}
+		}
+		if !slices.ContainsFunc(names, func(name string) bool { return slices.Contains(enabled, name) }) {
+			return fmt.Errorf("query %s: none of exporters %v is enabled", q.GetName(), names)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+)
+
+// Exporter is an alias of exporter.Exporter so that drivers need not import both packages named exporter.
+type Exporter = exporter.Exporter
+
+// PlanWriter is an exporter that can print results of dry runs.
+type PlanWriter = exporter.PlanWriter
+
+// Driver represents ...
+type Driver interface {
+	// Options returns a pointer to a new struct that holds options of the exporter with default values.
+	// Its fields are tagged with json, flag and usage in the same way as option.HandlerOptions.
+	Options() any
+
+	// OpenWithContext returns an exporter configured with opts that is returned by Options.
+	OpenWithContext(ctx context.Context, opts any, env *Env) (Exporter, error)
+}
+
+// Env holds values that are shared by all exporters.
+type Env struct {
+	Interval time.Duration
+	Queries  []query.Query
+	Logger   logr.Logger
+}
@@ -0,0 +1,40 @@
+package graphite
+
+import (
+	"context"
+	"time"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/graphite"
+)
+
+func init() {
+	exporter.Register(graphite.Name, &Driver{})
+}
+
+// Options represents options of the graphite exporter.
+type Options struct {
+	Address      string          `json:"graphite-address" flag:"graphite-address" usage:"^address^ (host:port) of the carbon plaintext receiver"`
+	Prefix       string          `json:"graphite-prefix" flag:"graphite-prefix" usage:"^prefix^ of paths written by the graphite exporter"`
+	WriteTimeout option.Duration `json:"graphite-write-timeout" flag:"graphite-write-timeout" usage:"^timeout^ of each write by the graphite exporter"`
+}
+
+// Driver represents ...
+type Driver struct{}
+
+// Options returns default options.
+func (d *Driver) Options() any {
+	return &Options{Address: "localhost:2003"}
+}
+
+// OpenWithContext is ...
+func (d *Driver) OpenWithContext(_ context.Context, opts any, _ *driver.Env) (driver.Exporter, error) {
+	o := opts.(*Options)
+	return graphite.NewExporter(&graphite.Config{
+		Address:      o.Address,
+		Prefix:       o.Prefix,
+		WriteTimeout: time.Duration(o.WriteTimeout),
+	}), nil
+}
@@ -0,0 +1,53 @@
+package influx
+
+import (
+	"context"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/influx"
+)
+
+func init() {
+	exporter.Register(influx.Name, &Driver{})
+}
+
+// Options represents options of the influx exporter.
+type Options struct {
+	URL       string `json:"influx-url" flag:"influx-url" usage:"base ^url^ of InfluxDB, or file:///path to append lines to the file"`
+	OrgRef    string `json:"influx-org" flag:"influx-org" usage:"InfluxDB ^organization^"`
+	BucketRef string `json:"influx-bucket" flag:"influx-bucket" usage:"InfluxDB ^bucket^"`
+	TokenRef  string `json:"influx-token" flag:"influx-token" usage:"InfluxDB API ^token^"`
+}
+
+// Driver represents ...
+type Driver struct{}
+
+// Options returns default options.
+func (d *Driver) Options() any {
+	return &Options{}
+}
+
+// OpenWithContext is ...
+func (d *Driver) OpenWithContext(ctx context.Context, opts any, _ *driver.Env) (driver.Exporter, error) {
+	o := opts.(*Options)
+	org, err := option.FetchString(ctx, o.OrgRef)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := option.FetchString(ctx, o.BucketRef)
+	if err != nil {
+		return nil, err
+	}
+	token, err := option.FetchString(ctx, o.TokenRef)
+	if err != nil {
+		return nil, err
+	}
+	return influx.NewExporter(&influx.Config{
+		URL:    o.URL,
+		Org:    org,
+		Bucket: bucket,
+		Token:  token,
+	})
+}
@@ -0,0 +1,62 @@
+package mackerel
+
+import (
+	"context"
+	"time"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/mackerel"
+)
+
+func init() {
+	exporter.Register(mackerel.Name, &Driver{})
+}
+
+// Options represents options of the mackerel exporter.
+type Options struct {
+	APIKeyRef  string          `json:"mackerel-apikey" flag:"mackerel-apikey" usage:"mackerel ^apikey^"`
+	APIBaseRef string          `json:"mackerel-apibase" flag:"mackerel-apibase" usage:"mackerel apibase ^url^"`
+	Timeout    option.Duration `json:"mackerel-timeout" flag:"mackerel-timeout" usage:"^timeout^ of each request to mackerel"`
+	Proxy      string          `json:"mackerel-proxy" flag:"mackerel-proxy" usage:"^url^ of the http proxy to mackerel; empty means HTTPS_PROXY"`
+	CACertRef  string          `json:"mackerel-ca-cert" flag:"mackerel-ca-cert" usage:"PEM encoded CA ^certificates^ to verify mackerel"`
+	HeadersRef string          `json:"mackerel-headers" flag:"mackerel-headers" usage:"comma-separated ^key=value^ headers sent to mackerel"`
+}
+
+// Driver represents ...
+type Driver struct{}
+
+// Options returns default options.
+func (d *Driver) Options() any {
+	return &Options{Timeout: option.Duration(30 * time.Second)}
+}
+
+// OpenWithContext is ...
+func (d *Driver) OpenWithContext(ctx context.Context, opts any, _ *driver.Env) (driver.Exporter, error) {
+	o := opts.(*Options)
+	apiKey, err := option.FetchString(ctx, o.APIKeyRef)
+	if err != nil {
+		return nil, err
+	}
+	apiBase, err := option.FetchString(ctx, o.APIBaseRef)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := option.FetchString(ctx, o.CACertRef)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := option.FetchString(ctx, o.HeadersRef)
+	if err != nil {
+		return nil, err
+	}
+	return mackerel.NewExporterWithConfig(&mackerel.Config{
+		APIKey:  apiKey,
+		APIBase: apiBase,
+		Timeout: time.Duration(o.Timeout),
+		Proxy:   o.Proxy,
+		CACert:  caCert,
+		Headers: option.ParseHeaders(headers),
+	})
+}
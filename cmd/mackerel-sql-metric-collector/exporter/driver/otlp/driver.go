@@ -0,0 +1,51 @@
+package otlp
+
+import (
+	"context"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/otlp"
+)
+
+func init() {
+	exporter.Register(otlp.Name, &Driver{})
+}
+
+// Options represents options of the otlp exporter.
+type Options struct {
+	Endpoint   string `json:"otlp-endpoint" flag:"otlp-endpoint" usage:"^url^ (http/protobuf) or address (grpc) of the otlp receiver"`
+	Protocol   string `json:"otlp-protocol" flag:"otlp-protocol" usage:"^protocol^ of the otlp exporter [http/protobuf, grpc]"`
+	HeadersRef string `json:"otlp-headers" flag:"otlp-headers" usage:"comma-separated ^key=value^ headers sent by the otlp exporter"`
+	Insecure   bool   `json:"otlp-insecure" flag:"otlp-insecure" usage:"disable TLS of grpc connections of the otlp exporter"`
+	CACertRef  string `json:"otlp-ca-cert" flag:"otlp-ca-cert" usage:"PEM encoded CA ^certificates^ to verify the otlp receiver"`
+}
+
+// Driver represents ...
+type Driver struct{}
+
+// Options returns default options.
+func (d *Driver) Options() any {
+	return &Options{Protocol: otlp.ProtocolHTTP}
+}
+
+// OpenWithContext is ...
+func (d *Driver) OpenWithContext(ctx context.Context, opts any, _ *driver.Env) (driver.Exporter, error) {
+	o := opts.(*Options)
+	headers, err := option.FetchString(ctx, o.HeadersRef)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := option.FetchString(ctx, o.CACertRef)
+	if err != nil {
+		return nil, err
+	}
+	return otlp.NewExporter(&otlp.Config{
+		Endpoint: o.Endpoint,
+		Protocol: o.Protocol,
+		Headers:  option.ParseHeaders(headers),
+		Insecure: o.Insecure,
+		CACert:   caCert,
+	})
+}
@@ -0,0 +1,67 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/prometheus"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+)
+
+func init() {
+	exporter.Register(prometheus.Name, &Driver{})
+}
+
+// Options represents options of the prometheus exporter.
+type Options struct {
+	Listen string `json:"prometheus-listen" flag:"prometheus-listen" usage:"^address^ to serve /metrics for the prometheus exporter"`
+	Labels bool   `json:"prometheus-labels" flag:"prometheus-labels" usage:"expose #{column} parts of metric names as labels in the prometheus exporter"`
+}
+
+// Driver represents ...
+type Driver struct{}
+
+// Options returns default options.
+func (d *Driver) Options() any {
+	return &Options{Listen: ":9237"}
+}
+
+// OpenWithContext starts serving /metrics until ctx is done.
+func (d *Driver) OpenWithContext(ctx context.Context, opts any, env *driver.Env) (driver.Exporter, error) {
+	o := opts.(*Options)
+	if env.Interval <= 0 {
+		return nil, fmt.Errorf("%s exporter requires --interval", prometheus.Name)
+	}
+	var templates []string
+	if o.Labels {
+		templates = metricNameTemplates(env.Queries)
+	}
+	e, err := prometheus.NewExporter(templates)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", o.Listen)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := e.Serve(ctx, ln); err != nil {
+			env.Logger.Error(err, "failed to serve metrics")
+		}
+	}()
+	return e, nil
+}
+
+// metricNameTemplates returns names of metrics that queries may post.
+func metricNameTemplates(queries []query.Query) []string {
+	var names []string
+	for _, q := range queries {
+		if s, ok := q.(query.Statement); ok {
+			names = append(names, s.MetricNames()...)
+		}
+	}
+	return names
+}
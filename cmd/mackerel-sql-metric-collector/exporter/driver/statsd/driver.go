@@ -0,0 +1,42 @@
+package statsd
+
+import (
+	"context"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/statsd"
+)
+
+func init() {
+	exporter.Register(statsd.Name, &Driver{})
+}
+
+// Options represents options of the statsd exporter.
+type Options struct {
+	Address       string `json:"statsd-address" flag:"statsd-address" usage:"^address^ (host:port or unix:///path) of the statsd server"`
+	Format        string `json:"statsd-format" flag:"statsd-format" usage:"line ^format^ of the statsd exporter [statsd, dogstatsd]"`
+	Prefix        string `json:"statsd-prefix" flag:"statsd-prefix" usage:"^prefix^ of metric names sent by the statsd exporter"`
+	Tags          bool   `json:"statsd-tags" flag:"statsd-tags" usage:"add the service and the query name as tags (dogstatsd only)"`
+	MaxPacketSize int    `json:"statsd-max-packet-size" flag:"statsd-max-packet-size" usage:"maximum ^bytes^ of each packet sent by the statsd exporter"`
+}
+
+// Driver represents ...
+type Driver struct{}
+
+// Options returns default options.
+func (d *Driver) Options() any {
+	return &Options{Address: "localhost:8125", Format: statsd.FormatStatsD}
+}
+
+// OpenWithContext is ...
+func (d *Driver) OpenWithContext(_ context.Context, opts any, _ *driver.Env) (driver.Exporter, error) {
+	o := opts.(*Options)
+	return statsd.NewExporter(&statsd.Config{
+		Address:       o.Address,
+		Format:        o.Format,
+		Prefix:        o.Prefix,
+		Tags:          o.Tags,
+		MaxPacketSize: o.MaxPacketSize,
+	})
+}
@@ -0,0 +1,33 @@
+package stdout
+
+import (
+	"context"
+	"os"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/stdout"
+)
+
+func init() {
+	exporter.Register(stdout.Name, &Driver{})
+}
+
+// Options represents options of the stdout exporter.
+type Options struct {
+	Format string `json:"stdout-format" flag:"stdout-format" usage:"output ^format^ of the stdout exporter [tsv, json, ndjson, csv, table]"`
+}
+
+// Driver represents ...
+type Driver struct{}
+
+// Options returns default options.
+func (d *Driver) Options() any {
+	return &Options{Format: stdout.FormatTSV}
+}
+
+// OpenWithContext is ...
+func (d *Driver) OpenWithContext(_ context.Context, opts any, _ *driver.Env) (driver.Exporter, error) {
+	o := opts.(*Options)
+	return stdout.NewExporterWithFormat(os.Stdout, o.Format)
+}
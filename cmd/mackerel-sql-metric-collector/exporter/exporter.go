@@ -0,0 +1,64 @@
+// Package exporter provides a registry of exporters that metrics are sent to.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]driver.Driver)
+)
+
+// Register is ...
+func Register(name string, d driver.Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if d == nil {
+		panic("register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("register called twice for driver " + name)
+	}
+	drivers[name] = d
+}
+
+// Names returns names of registered drivers in lexical order.
+func Names() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	return slices.Sorted(maps.Keys(drivers))
+}
+
+// Options returns new options of the driver with default values.
+// It returns nil if the driver is not registered.
+func Options(name string) any {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	d, ok := drivers[name]
+	if !ok {
+		return nil
+	}
+	return d.Options()
+}
+
+// OpenWithContext returns an exporter of the driver configured with opts.
+// If opts is nil, the exporter is configured with default options.
+func OpenWithContext(ctx context.Context, name string, opts any, env *driver.Env) (driver.Exporter, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown exporter", name)
+	}
+	if opts == nil {
+		opts = d.Options()
+	}
+	return d.OpenWithContext(ctx, opts, env)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/go-logr/stdr"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/stdout"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+)
+
+type countDriver struct {
+	n int
+}
+
+func (d *countDriver) Options() any {
+	return nil
+}
+
+func (d *countDriver) OpenWithContext(context.Context, any, *driver.Env) (driver.Exporter, error) {
+	d.n++
+	return stdout.NewExporterWithFormat(io.Discard, stdout.FormatTSV)
+}
+
+var testCountDriver = &countDriver{}
+
+func init() {
+	exporter.Register("count", testCountDriver)
+}
+
+func TestNewExporters_duplicate(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	conf := &option.Config{Exporters: []string{"count", stdout.Name, "count"}}
+	e, _, err := newExporters(context.Background(), conf, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.(io.Closer).Close() // nolint
+	if testCountDriver.n != 1 {
+		t.Errorf("newExporters opened the count exporter %d times; want 1", testCountDriver.n)
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	testCases := map[string]struct {
+		exporters []string
+		wantErr   bool
+	}{
+		"default":      {},
+		"enabled":      {exporters: []string{"count", stdout.Name}},
+		"unknown":      {exporters: []string{"count", "vpc"}, wantErr: true},
+		"none_enabled": {exporters: []string{stdout.Name}, wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			queries := []query.Query{&valuekey.Query{Name: "q", Exporters: tc.exporters}}
+			err := checkRoutes(queries, []string{"count"})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("checkRoutes: got %v; want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
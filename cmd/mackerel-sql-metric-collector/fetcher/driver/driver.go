@@ -10,3 +10,10 @@ type Driver interface {
 	Fetch(*url.URL) ([]byte, error)
 	FetchWithContext(context.Context, *url.URL) ([]byte, error)
 }
+
+// Lister is implemented by drivers that can expand a URL to multiple resources.
+type Lister interface {
+	// ListWithContext returns URLs of resources that match with the URL such as a directory or a glob pattern.
+	// It returns nil if the URL points to a single resource.
+	ListWithContext(context.Context, *url.URL) ([]*url.URL, error)
+}
@@ -2,8 +2,12 @@ package file
 
 import (
 	"context"
+	"errors"
+	"io/fs"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/fetcher"
 )
@@ -24,3 +28,55 @@ func (d *Driver) Fetch(u *url.URL) ([]byte, error) {
 func (d *Driver) FetchWithContext(_ context.Context, u *url.URL) ([]byte, error) {
 	return os.ReadFile(u.Path)
 }
+
+// ListWithContext returns regular files in the directory or files that match with the glob pattern.
+// The path is a glob pattern only if it does not exist, so names that contain such as "[" can be loaded as is.
+// Files whose name starts with "." are ignored to skip such as "..data" in Kubernetes ConfigMap volumes.
+func (d *Driver) ListWithContext(_ context.Context, u *url.URL) ([]*url.URL, error) {
+	var paths []string
+	fi, err := os.Stat(u.Path)
+	switch {
+	case err == nil:
+		if !fi.IsDir() {
+			return nil, nil
+		}
+		entries, err := os.ReadDir(u.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			paths = append(paths, filepath.Join(u.Path, e.Name()))
+		}
+	case errors.Is(err, fs.ErrNotExist) && hasMeta(u.Path):
+		matches, err := filepath.Glob(u.Path)
+		if err != nil {
+			return nil, err
+		}
+		paths = matches
+	default:
+		return nil, err
+	}
+
+	urls := []*url.URL{}
+	for _, p := range paths {
+		if strings.HasPrefix(filepath.Base(p), ".") {
+			continue
+		}
+		fi, err := os.Stat(p) // follow symlinks
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		v := *u
+		v.Path = filepath.ToSlash(p)
+		v.RawPath = ""
+		urls = append(urls, &v)
+	}
+	return urls, nil
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
@@ -3,6 +3,7 @@ package s3
 import (
 	"context"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -39,7 +40,50 @@ func (d *Driver) FetchWithContext(ctx context.Context, u *url.URL) ([]byte, erro
 		return nil, err
 	}
 
-	return fetchFromS3(ctx, c, u.Host, u.Path)
+	return fetchFromS3(ctx, manager.NewDownloader(c), u.Host, u.Path)
+}
+
+// ListWithContext returns objects under the prefix if u ends with "/", or objects that match with the glob pattern.
+// Objects under sub-prefixes are not included.
+func (d *Driver) ListWithContext(ctx context.Context, u *url.URL) ([]*url.URL, error) {
+	key := strings.TrimPrefix(u.Path, "/")
+	i := strings.IndexAny(key, "*?[")
+	if i < 0 && key != "" && !strings.HasSuffix(key, "/") {
+		return nil, nil
+	}
+
+	pattern := key
+	prefix := key
+	if i >= 0 {
+		prefix = key[:i]
+	} else {
+		pattern = key + "*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	c, err := createS3Client(ctx, u.Host, resolveRegionHint(u))
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := listFromS3(ctx, c, u.Host, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := []*url.URL{}
+	for _, k := range keys {
+		if ok, _ := path.Match(pattern, k); !ok || strings.HasSuffix(k, "/") {
+			continue
+		}
+		v := *u
+		v.Path = "/" + k
+		v.RawPath = ""
+		urls = append(urls, &v)
+	}
+	return urls, nil
 }
 
 func resolveRegionHint(u *url.URL) string {
@@ -50,7 +94,7 @@ func resolveRegionHint(u *url.URL) string {
 	return defaultRegionHint
 }
 
-func createS3Client(ctx context.Context, bucket, regionHint string) (*manager.Downloader, error) {
+func createS3Client(ctx context.Context, bucket, regionHint string) (*s3.Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(regionHint))
 	if err != nil {
 		return nil, err
@@ -62,7 +106,7 @@ func createS3Client(ctx context.Context, bucket, regionHint string) (*manager.Do
 	}
 	cfg.Region = r
 
-	return manager.NewDownloader(s3.NewFromConfig(cfg)), nil
+	return s3.NewFromConfig(cfg), nil
 }
 
 func fetchFromS3(ctx context.Context, client *manager.Downloader, bucket, key string) ([]byte, error) {
@@ -80,3 +124,23 @@ func fetchFromS3(ctx context.Context, client *manager.Downloader, bucket, key st
 
 	return buf.Bytes(), nil
 }
+
+func listFromS3(ctx context.Context, client *s3.Client, bucket, prefix string) ([]string, error) {
+	var keys []string
+
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+		}
+	}
+
+	return keys, nil
+}
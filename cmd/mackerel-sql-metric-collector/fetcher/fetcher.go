@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/fetcher/driver"
@@ -48,6 +50,33 @@ func FetchWithContext(ctx context.Context, u *url.URL) ([]byte, error) {
 	return driver.FetchWithContext(ctx, u)
 }
 
+// ListWithContext returns URLs that match with u in lexical order.
+// It returns nil if u points to a single resource or its driver does not support listing.
+func ListWithContext(ctx context.Context, u *url.URL) ([]*url.URL, error) {
+	name := u.Scheme
+	if name == "" {
+		name = "file"
+	}
+
+	d, ok := isRegistered(name)
+	if !ok {
+		return nil, fmt.Errorf("%s driver not registered", name)
+	}
+
+	l, ok := d.(driver.Lister)
+	if !ok {
+		return nil, nil
+	}
+	urls, err := l.ListWithContext(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(urls, func(a, b *url.URL) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return urls, nil
+}
+
 // IsRegistered is ...
 func IsRegistered(name string) bool {
 	_, ok := isRegistered(name)
@@ -5,8 +5,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/stdr"
@@ -16,9 +20,6 @@ import (
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/executor/driver/cli"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/executor/driver/lambda"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
-	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
-	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/mackerel"
-	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/stdout"
 )
 
 var revision string
@@ -39,7 +40,16 @@ func exits(err error) {
 }
 
 func run(name string, args []string) error {
-	ctx := context.TODO() // TODO: signal handling.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(args) > 0 && args[0] == "validate" {
+		err := runValidate(ctx, name+" validate", args[1:], os.Stdout)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
 
 	conf, err := option.Parse(name, args)
 	if err != nil {
@@ -61,33 +71,72 @@ func handlerFunc(name string) func(context.Context, *option.Config) error {
 			return err
 		}
 
-		var exp exporter.Exporter
-		switch conf.Exporter {
-		case stdout.Name:
-			exp = stdout.NewExporter()
-		case mackerel.Name:
-			var err error
-			exp, err = mackerel.NewExporter(conf.MackerelAPIKey, conf.MackerelAPIBase)
+		queries, err := loadQueryWithContext(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if conf.DryRun {
+			c, err := collector.NewCollector(conf.CollectorConfig, nil, logger)
+			if err != nil {
+				return err
+			}
+			w, err := newPlanWriter(ctx, conf, logger)
 			if err != nil {
 				return err
 			}
-		default:
-			return fmt.Errorf("%s: unknown exporter", conf.Exporter)
+			if c, ok := w.(io.Closer); ok {
+				defer c.Close() // nolint
+			}
+			logger.Info(fmt.Sprintf("start %s", name), "revision", revision)
+			return c.DryRunWithContext(ctx, queries, w)
 		}
 
-		c, err := collector.NewCollector(conf.CollectorConfig, exp, logger)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		exp, spools, err := newExporters(ctx, conf, queries, logger)
 		if err != nil {
 			return err
 		}
+		if c, ok := exp.(io.Closer); ok {
+			defer c.Close() // nolint
+		}
 
-		queries, err := loadQueryWithContext(ctx, conf.QueryFilePath)
+		c, err := collector.NewCollector(conf.CollectorConfig, exp, logger)
 		if err != nil {
 			return err
 		}
 
 		logger.Info(fmt.Sprintf("start %s", name), "revision", revision)
 
-		return c.RunWithContext(ctx, queries)
+		return runEvery(ctx, conf.Interval, logger, func(ctx context.Context) error {
+			for _, s := range spools {
+				if err := s.Replay(ctx, queries); err != nil {
+					logger.Error(err, "failed to replay spooled metrics")
+				}
+			}
+			return c.RunWithContext(ctx, queries)
+		})
+	}
+}
+
+// runEvery calls fn every interval until ctx is done. Errors are logged and do not stop the loop.
+// If interval is zero, it calls fn only once and returns its error.
+func runEvery(ctx context.Context, interval time.Duration, logger logr.Logger, fn func(context.Context) error) error {
+	if interval <= 0 {
+		return fn(ctx)
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := fn(ctx); err != nil {
+			logger.Error(err, "failed to collect metrics")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
 	}
 }
 
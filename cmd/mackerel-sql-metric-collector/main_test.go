@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/go-logr/stdr"
+)
+
+func TestRunEvery(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	errTest := errors.New("test")
+
+	var n int
+	err := runEvery(context.Background(), 0, logger, func(context.Context) error {
+		n++
+		return errTest
+	})
+	if !errors.Is(err, errTest) || n != 1 {
+		t.Errorf("runEvery(0) = %v and called %d times; want %v and once", err, n, errTest)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	err = runEvery(ctx, time.Millisecond, logger, func(context.Context) error {
+		n++
+		if n == 3 {
+			cancel()
+		}
+		return errTest
+	})
+	if err != nil || n != 3 {
+		t.Errorf("runEvery(1ms) = %v and called %d times; want nil and 3 times", err, n)
+	}
+}
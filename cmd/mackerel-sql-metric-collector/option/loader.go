@@ -13,11 +13,17 @@ func (c *Config) Load(ctx context.Context) error {
 	var f errFetcher
 	c.CollectorConfig.DSN = f.FetchString(ctx, c.DSNRef)
 	c.CollectorConfig.DefaultService = f.FetchString(ctx, c.DefaultServiceRef)
-	c.MackerelAPIKey = f.FetchString(ctx, c.MackerelAPIKeyRef)
-	c.MackerelAPIBase = f.FetchString(ctx, c.MackerelAPIBaseRef)
+	c.CollectorConfig.CheckHost = f.FetchString(ctx, c.CheckHostRef)
 	return f.err
 }
 
+// FetchString returns the content of s if s is a URL of registered fetchers such as ssm://; otherwise it returns s.
+func FetchString(ctx context.Context, s string) (string, error) {
+	var f errFetcher
+	v := f.FetchString(ctx, s)
+	return v, f.err
+}
+
 type errFetcher struct {
 	err error
 }
@@ -2,32 +2,77 @@
 package option
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
+	"time"
 
 	collector "github.com/mackerelio-labs/mackerel-sql-metric-collector"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/mackerel"
-	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/stdout"
 )
 
-// FIXME: It contains expected values to usage tag of both LogFormat and LogLevel.
-var exporterNames = []string{mackerel.Name, stdout.Name} // nolint
-
 // HandlerOptions is used to configure the handler.
 type HandlerOptions struct {
-	DSNRef            string `json:"dsn" flag:"dsn" usage:"datasource name"`
-	DefaultServiceRef string `json:"default-service" flag:"default-service" usage:"default mackerel service ^name^"`
-	MaxConcurrency    int    `json:"max-concurrency" flag:"max-concurrency" usage:"maximum ^number^ of concurrent queries"`
+	DSNRef            string   `json:"dsn" flag:"dsn" usage:"datasource name"`
+	DefaultServiceRef string   `json:"default-service" flag:"default-service" usage:"default mackerel service ^name^"`
+	CheckHostRef      string   `json:"check-host" flag:"check-host" usage:"^host ID^ that check reports belong to, such as file:///var/lib/mackerel-agent/id"`
+	MaxConcurrency    int      `json:"max-concurrency" flag:"max-concurrency" usage:"maximum ^number^ of concurrent queries"`
+	MaxRows           int      `json:"max-rows" flag:"max-rows" usage:"default maximum ^number^ of rows processed per query; zero means unlimited"`
+	MaxSeries         int      `json:"max-series" flag:"max-series" usage:"maximum ^number^ of series posted in a run; zero means unlimited"`
+	SeriesOverflow    string   `json:"series-overflow" flag:"series-overflow" usage:"^policy^ for series over max-series [drop, error]"`
+	Heartbeat         string   `json:"heartbeat" flag:"heartbeat" usage:"^name^ of the check monitoring that reports the result of each run to check-host"`
+	DryRun            bool     `json:"dry-run" flag:"dry-run" usage:"explain queries and print metric names without executing queries or exporting"`
+	Interval          Duration `json:"-" flag:"interval" usage:"run queries every ^duration^ until interrupted; zero runs once"`
+
+	QueryFilePath       string `json:"query-file" flag:"query-file" usage:"query file (yaml, json or toml) ^filename^, directory or glob pattern"`
+	QueryEnv            string `json:"query-env" flag:"query-env" usage:"comma-separated ^names^ of environment variables expanded in query files; trailing * matches any suffix"`
+	Only                string `json:"only" flag:"only" usage:"comma-separated query ^names^ to run"`
+	Tags                string `json:"tags" flag:"tags" usage:"run only queries that have any of comma-separated ^tags^"`
+	SkipTags            string `json:"skip-tags" flag:"skip-tags" usage:"skip queries that have any of comma-separated ^tags^"`
+	Exporter            string `json:"exporter" flag:"exporter" usage:"comma-separated exporters to ^backend^ services"`
+	BestEffortExporters string `json:"best-effort-exporters" flag:"best-effort-exporters" usage:"comma-separated exporter ^names^ whose failures are only logged"`
+	LogFormat           string `json:"log-format" flag:"log-format" usage:"log ^format^ [console, json]"`
+	LogLevel            string `json:"log-level" flag:"log-level" usage:"log ^level^ [info, error]"`
+
+	Spool          string   `json:"spool" flag:"spool" usage:"^url^ of the directory (file:///path) or the S3 prefix (s3://bucket/prefix/) to spool metrics that failed to export"`
+	SpoolMaxAge    Duration `json:"spool-max-age" flag:"spool-max-age" usage:"maximum ^age^ of spooled metrics to export again; zero means unlimited"`
+	SpoolExporters string   `json:"spool-exporters" flag:"spool-exporters" usage:"comma-separated exporter ^names^ whose failures are spooled"`
+
+	// ExporterOptions holds options of each exporter by its name.
+	// They are registered as flags and unmarshaled from the same JSON object as HandlerOptions.
+	ExporterOptions map[string]any `json:"-" flag:"-"`
+}
+
+// Duration is time.Duration that can be set by flags.
+type Duration time.Duration
+
+// String implements flag.Value.
+func (d *Duration) String() string {
+	return time.Duration(*d).String()
+}
 
-	QueryFilePath      string `json:"query-file" flag:"query-file" usage:"query yaml ^filename^"`
-	MackerelAPIKeyRef  string `json:"mackerel-apikey" flag:"mackerel-apikey" usage:"mackerel ^apikey^"`
-	MackerelAPIBaseRef string `json:"mackerel-apibase" flag:"mackerel-apibase" usage:"mackerel apibase ^url^"`
-	Exporter           string `json:"exporter" flag:"exporter" usage:"exporter to ^backend^ service [mackerel, stdout]"`
-	LogFormat          string `json:"log-format" flag:"log-format" usage:"log ^format^ [console, json]"`
-	LogLevel           string `json:"log-level" flag:"log-level" usage:"log ^level^ [info, error]"`
+// Set implements flag.Value.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UnmarshalJSON accepts a duration string such as "10s".
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
 }
 
 var defaultHandlerOptions = HandlerOptions{
@@ -35,6 +80,9 @@ var defaultHandlerOptions = HandlerOptions{
 	Exporter:       mackerel.Name,
 	LogFormat:      "console",
 	LogLevel:       "info",
+
+	SpoolMaxAge:    Duration(24 * time.Hour),
+	SpoolExporters: mackerel.Name,
 }
 
 var methods = map[reflect.Kind]string{
@@ -46,6 +94,13 @@ var methods = map[reflect.Kind]string{
 // Flags returns a pointer to flag.FlagSet that sets option values to corresponding to opts.
 func Flags(name string, opts any) (*flag.FlagSet, error) {
 	c := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := addFlags(c, opts); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func addFlags(c *flag.FlagSet, opts any) error {
 	p := reflect.ValueOf(opts)
 	for i, field := range reflect.VisibleFields(p.Elem().Type()) {
 		tag := field.Tag.Get("flag")
@@ -83,9 +138,9 @@ func Flags(name string, opts any) (*flag.FlagSet, error) {
 			})
 			continue
 		}
-		return nil, fmt.Errorf("field '%s': unsupported kind", field.Name)
+		return fmt.Errorf("field '%s': unsupported kind", field.Name)
 	}
-	return c, nil
+	return nil
 }
 
 func usage(f reflect.StructField) string {
@@ -98,17 +153,30 @@ func usage(f reflect.StructField) string {
 // Config contains configuration values of the SQL Metric Collector.
 type Config struct {
 	CollectorConfig *collector.Config
+	DryRun          bool
+	Interval        time.Duration
+	MaxRows         int
 	QueryFilePath   string
-	MackerelAPIKey  string
-	MackerelAPIBase string
-	Exporter        string
+	QueryEnv        []string
+	Only            []string
+	Tags            []string
+	SkipTags        []string
+	Exporters       []string
 	LogFormat       string
 	LogLevel        string
 
-	DSNRef             string
-	DefaultServiceRef  string
-	MackerelAPIKeyRef  string
-	MackerelAPIBaseRef string
+	BestEffortExporters []string
+
+	Spool          string
+	SpoolMaxAge    time.Duration
+	SpoolExporters []string
+
+	// ExporterOptions holds options of each exporter by its name.
+	ExporterOptions map[string]any
+
+	DSNRef            string
+	DefaultServiceRef string
+	CheckHostRef      string
 }
 
 // ToConfig returns Config that is initialized with corresponding fields of opts.
@@ -116,16 +184,33 @@ func (opts *HandlerOptions) ToConfig() *Config {
 	return &Config{
 		CollectorConfig: &collector.Config{
 			MaxConcurrency: opts.MaxConcurrency,
+			MaxSeries:      opts.MaxSeries,
+			SeriesOverflow: opts.SeriesOverflow,
+			Heartbeat:      opts.Heartbeat,
 		},
+		DryRun:        opts.DryRun,
+		Interval:      time.Duration(opts.Interval),
+		MaxRows:       opts.MaxRows,
 		QueryFilePath: opts.QueryFilePath,
-		Exporter:      opts.Exporter,
+		QueryEnv:      SplitList(opts.QueryEnv),
+		Only:          SplitList(opts.Only),
+		Tags:          SplitList(opts.Tags),
+		SkipTags:      SplitList(opts.SkipTags),
+		Exporters:     SplitList(opts.Exporter),
 		LogFormat:     opts.LogFormat,
 		LogLevel:      opts.LogLevel,
 
-		DSNRef:             opts.DSNRef,
-		DefaultServiceRef:  opts.DefaultServiceRef,
-		MackerelAPIKeyRef:  opts.MackerelAPIKeyRef,
-		MackerelAPIBaseRef: opts.MackerelAPIBaseRef,
+		BestEffortExporters: SplitList(opts.BestEffortExporters),
+
+		Spool:          opts.Spool,
+		SpoolMaxAge:    time.Duration(opts.SpoolMaxAge),
+		SpoolExporters: SplitList(opts.SpoolExporters),
+
+		ExporterOptions: cloneExporterOptions(opts.ExporterOptions),
+
+		DSNRef:            opts.DSNRef,
+		DefaultServiceRef: opts.DefaultServiceRef,
+		CheckHostRef:      opts.CheckHostRef,
 	}
 }
 
@@ -134,20 +219,80 @@ func (c *Config) DeepCopy() *Config {
 	cc := *c.CollectorConfig
 	nc := *c
 	nc.CollectorConfig = &cc
+	nc.QueryEnv = slices.Clone(c.QueryEnv)
+	nc.Only = slices.Clone(c.Only)
+	nc.Tags = slices.Clone(c.Tags)
+	nc.SkipTags = slices.Clone(c.SkipTags)
+	nc.Exporters = slices.Clone(c.Exporters)
+	nc.BestEffortExporters = slices.Clone(c.BestEffortExporters)
+	nc.SpoolExporters = slices.Clone(c.SpoolExporters)
+	nc.ExporterOptions = cloneExporterOptions(c.ExporterOptions)
 	return &nc
 }
 
 // Merge updates each fields of c with corresponding field of opts if opts's field value is not zero.
 func (c *Config) Merge(opts *HandlerOptions) {
 	updateValue(&c.CollectorConfig.MaxConcurrency, opts.MaxConcurrency)
+	updateValue(&c.CollectorConfig.MaxSeries, opts.MaxSeries)
+	updateValue(&c.CollectorConfig.SeriesOverflow, opts.SeriesOverflow)
+	updateValue(&c.CollectorConfig.Heartbeat, opts.Heartbeat)
+	updateValue(&c.DryRun, opts.DryRun)
+	updateValue(&c.MaxRows, opts.MaxRows)
 	updateValue(&c.QueryFilePath, opts.QueryFilePath)
-	updateValue(&c.Exporter, opts.Exporter)
+	updateList(&c.QueryEnv, opts.QueryEnv)
+	updateList(&c.Only, opts.Only)
+	updateList(&c.Tags, opts.Tags)
+	updateList(&c.SkipTags, opts.SkipTags)
+	updateList(&c.Exporters, opts.Exporter)
+	updateList(&c.BestEffortExporters, opts.BestEffortExporters)
+	updateValue(&c.Spool, opts.Spool)
+	updateValue(&c.SpoolMaxAge, time.Duration(opts.SpoolMaxAge))
+	updateList(&c.SpoolExporters, opts.SpoolExporters)
 	updateValue(&c.LogFormat, opts.LogFormat)
 	updateValue(&c.LogLevel, opts.LogLevel)
 	updateValue(&c.DSNRef, opts.DSNRef)
 	updateValue(&c.DefaultServiceRef, opts.DefaultServiceRef)
-	updateValue(&c.MackerelAPIKeyRef, opts.MackerelAPIKeyRef)
-	updateValue(&c.MackerelAPIBaseRef, opts.MackerelAPIBaseRef)
+	updateValue(&c.CheckHostRef, opts.CheckHostRef)
+	for name, o := range opts.ExporterOptions {
+		if p, ok := c.ExporterOptions[name]; ok {
+			mergeOptions(p, o)
+			continue
+		}
+		if c.ExporterOptions == nil {
+			c.ExporterOptions = make(map[string]any)
+		}
+		c.ExporterOptions[name] = cloneOptions(o)
+	}
+}
+
+// SplitList splits comma-separated s into non-empty elements.
+func SplitList(s string) []string {
+	var a []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			a = append(a, v)
+		}
+	}
+	return a
+}
+
+// ParseHeaders parses comma-separated "key=value" pairs.
+func ParseHeaders(s string) map[string]string {
+	var h map[string]string
+	for _, kv := range SplitList(s) {
+		k, v, _ := strings.Cut(kv, "=")
+		if h == nil {
+			h = make(map[string]string)
+		}
+		h[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return h
+}
+
+func updateList(p *[]string, s string) {
+	if s != "" {
+		*p = SplitList(s)
+	}
 }
 
 func updateValue[T comparable](p *T, v T) {
@@ -157,6 +302,58 @@ func updateValue[T comparable](p *T, v T) {
 	}
 }
 
+// UnmarshalJSON unmarshals data into both opts and options of each registered exporter.
+// Options of exporters that are not in data are left zero so that Merge does not update them.
+func (opts *HandlerOptions) UnmarshalJSON(data []byte) error {
+	type handlerOptions HandlerOptions
+	if err := json.Unmarshal(data, (*handlerOptions)(opts)); err != nil {
+		return err
+	}
+	opts.ExporterOptions = make(map[string]any)
+	for _, name := range exporter.Names() {
+		o := newOptions(exporter.Options(name))
+		if err := json.Unmarshal(data, o); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		opts.ExporterOptions[name] = o
+	}
+	return nil
+}
+
+func cloneExporterOptions(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]any, len(m))
+	for name, o := range m {
+		c[name] = cloneOptions(o)
+	}
+	return c
+}
+
+// newOptions returns a pointer to a zero value of the struct that o points to.
+func newOptions(o any) any {
+	return reflect.New(reflect.TypeOf(o).Elem()).Interface()
+}
+
+func cloneOptions(o any) any {
+	v := reflect.ValueOf(o).Elem()
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p.Interface()
+}
+
+// mergeOptions updates each fields of dst with corresponding field of src if src's field value is not zero.
+func mergeOptions(dst, src any) {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+	for i := range s.NumField() {
+		if f := s.Field(i); !f.IsZero() {
+			d.Field(i).Set(f)
+		}
+	}
+}
+
 // Parse parses the args; parsed values are set into corresponding fields of Config.
 func Parse(name string, args []string) (*Config, error) {
 	opts := defaultHandlerOptions
@@ -164,6 +361,18 @@ func Parse(name string, args []string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	names := exporter.Names()
+	opts.ExporterOptions = make(map[string]any)
+	for _, e := range names {
+		o := exporter.Options(e)
+		if err := addFlags(flags, o); err != nil {
+			return nil, fmt.Errorf("%s: %w", e, err)
+		}
+		opts.ExporterOptions[e] = o
+	}
+	if f := flags.Lookup("exporter"); f != nil {
+		f.Usage += " [" + strings.Join(names, ", ") + "]"
+	}
 
 	var setErr error
 	flags.VisitAll(func(f *flag.Flag) {
@@ -1,14 +1,22 @@
-package option
+package option_test
 
 import (
+	"context"
+	"encoding/json"
 	"io"
 	"log"
 	"net/url"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	collector "github.com/mackerelio-labs/mackerel-sql-metric-collector"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver"
+	mackereldriver "github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter/driver/mackerel"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/mackerel"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/stdout"
 )
 
@@ -36,7 +44,7 @@ func TestFlags(t *testing.T) {
 		Ignored  float64  `flag:"-"`
 	}
 
-	f, err := Flags("xx", &opts)
+	f, err := option.Flags("xx", &opts)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -62,7 +70,7 @@ func TestFlags_error(t *testing.T) {
 		Age int      `flag:"age"`
 		URL urlValue `flag:"url"`
 	}
-	f, err := Flags("xx", &opts)
+	f, err := option.Flags("xx", &opts)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -87,7 +95,7 @@ func Example_usage() {
 	}{
 		N: 5,
 	}
-	f, err := Flags("xx", &opts)
+	f, err := option.Flags("xx", &opts)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -102,67 +110,139 @@ func Example_usage() {
 	//     	bool
 }
 
+type testOptions struct {
+	Address string          `json:"test-address" flag:"test-address"`
+	Verbose bool            `json:"test-verbose" flag:"test-verbose"`
+	Timeout option.Duration `json:"test-timeout" flag:"test-timeout"`
+}
+
+type testDriver struct{}
+
+func (d *testDriver) Options() any {
+	return &testOptions{Address: "localhost:1234"}
+}
+
+func (d *testDriver) OpenWithContext(context.Context, any, *driver.Env) (driver.Exporter, error) {
+	return nil, nil
+}
+
+func init() {
+	exporter.Register("test", &testDriver{})
+}
+
 func TestHandlerOptions_ToConfig(t *testing.T) {
-	opts := &HandlerOptions{
-		DSNRef:             "host=127.1 port=123 user=root",
-		DefaultServiceRef:  "s3://example/service",
-		MaxConcurrency:     10,
-		QueryFilePath:      "file",
-		MackerelAPIKeyRef:  "ssm://mackerel/key",
-		MackerelAPIBaseRef: "ssm://mackerel/base",
-		Exporter:           stdout.Name,
-		LogFormat:          "json",
-		LogLevel:           "error",
+	opts := &option.HandlerOptions{
+		DSNRef:              "host=127.1 port=123 user=root",
+		DefaultServiceRef:   "s3://example/service",
+		CheckHostRef:        "file:///var/lib/mackerel-agent/id",
+		MaxConcurrency:      10,
+		MaxSeries:           500,
+		SeriesOverflow:      "error",
+		Heartbeat:           "collector",
+		DryRun:              true,
+		Interval:            option.Duration(time.Minute),
+		MaxRows:             1000,
+		QueryFilePath:       "file",
+		QueryEnv:            "APP_*, SERVICE",
+		Only:                "a,b",
+		Tags:                "hourly",
+		SkipTags:            "expensive",
+		Exporter:            "mackerel, test",
+		BestEffortExporters: "test",
+		LogFormat:           "json",
+		LogLevel:            "error",
+		Spool:               "file:///var/spool/sql",
+		SpoolMaxAge:         option.Duration(time.Hour),
+		SpoolExporters:      "mackerel,test",
+		ExporterOptions: map[string]any{
+			"test": &testOptions{Address: "example.com:1234", Verbose: true},
+		},
 	}
 	c := opts.ToConfig()
-	want := &Config{
+	want := &option.Config{
 		CollectorConfig: &collector.Config{
 			MaxConcurrency: 10,
+			MaxSeries:      500,
+			SeriesOverflow: "error",
+			Heartbeat:      "collector",
 		},
+		DryRun:        true,
+		Interval:      time.Minute,
+		MaxRows:       1000,
 		QueryFilePath: "file",
-		Exporter:      stdout.Name,
+		QueryEnv:      []string{"APP_*", "SERVICE"},
+		Only:          []string{"a", "b"},
+		Tags:          []string{"hourly"},
+		SkipTags:      []string{"expensive"},
+		Exporters:     []string{mackerel.Name, "test"},
 		LogFormat:     "json",
 		LogLevel:      "error",
 
-		DSNRef:             "host=127.1 port=123 user=root",
-		DefaultServiceRef:  "s3://example/service",
-		MackerelAPIKeyRef:  "ssm://mackerel/key",
-		MackerelAPIBaseRef: "ssm://mackerel/base",
+		BestEffortExporters: []string{"test"},
+
+		Spool:          "file:///var/spool/sql",
+		SpoolMaxAge:    time.Hour,
+		SpoolExporters: []string{mackerel.Name, "test"},
+
+		ExporterOptions: map[string]any{
+			"test": &testOptions{Address: "example.com:1234", Verbose: true},
+		},
+
+		DSNRef:            "host=127.1 port=123 user=root",
+		DefaultServiceRef: "s3://example/service",
+		CheckHostRef:      "file:///var/lib/mackerel-agent/id",
 	}
 	if !reflect.DeepEqual(c, want) {
 		t.Errorf("ToConfig() = %+v; but want %+v", c, want)
 	}
+	if c.ExporterOptions["test"] == opts.ExporterOptions["test"] {
+		t.Errorf("ToConfig() shares exporter options with HandlerOptions")
+	}
 }
 
 func TestConfig_Merge(t *testing.T) {
-	c := &Config{
+	c := &option.Config{
 		CollectorConfig: &collector.Config{
 			DSN:            "host=127.1 port=123 user=root",
 			DefaultService: "Service1",
 			MaxConcurrency: 10,
 		},
-		QueryFilePath:   "file",
-		MackerelAPIKey:  "xxxx",
-		MackerelAPIBase: "https://mackerel.io",
-		Exporter:        stdout.Name,
-		LogFormat:       "json",
-		LogLevel:        "error",
-
-		DSNRef:             "host=127.1 port=123 user=root",
-		DefaultServiceRef:  "s3://example/service",
-		MackerelAPIKeyRef:  "ssm://mackerel/key",
-		MackerelAPIBaseRef: "ssm://mackerel/base",
-	}
-	opts := &HandlerOptions{
-		DSNRef:             "host=127.2 port=123 user=root",
-		DefaultServiceRef:  "s3://example/service2",
-		MaxConcurrency:     20,
-		QueryFilePath:      "file2",
-		MackerelAPIKeyRef:  "ssm://mackerel/key2",
-		MackerelAPIBaseRef: "ssm://mackerel/base2",
-		Exporter:           stdout.Name,
-		LogFormat:          "console",
-		LogLevel:           "info",
+		QueryFilePath: "file",
+		QueryEnv:      []string{"APP_*"},
+		Exporters:     []string{stdout.Name},
+		LogFormat:     "json",
+		LogLevel:      "error",
+		ExporterOptions: map[string]any{
+			"test": &testOptions{Address: "localhost:1234", Verbose: true},
+		},
+
+		DSNRef:            "host=127.1 port=123 user=root",
+		DefaultServiceRef: "s3://example/service",
+	}
+	opts := &option.HandlerOptions{
+		DSNRef:              "host=127.2 port=123 user=root",
+		DefaultServiceRef:   "s3://example/service2",
+		CheckHostRef:        "host1",
+		MaxConcurrency:      20,
+		MaxSeries:           1000,
+		SeriesOverflow:      "drop",
+		Heartbeat:           "sql-collector",
+		QueryFilePath:       "file2",
+		MaxRows:             2000,
+		QueryEnv:            "STAGE_*",
+		Only:                "c",
+		Tags:                "daily",
+		SkipTags:            "slow",
+		Exporter:            "test,stdout",
+		BestEffortExporters: "test",
+		LogFormat:           "console",
+		LogLevel:            "info",
+		Spool:               "s3://bucket/spool/",
+		SpoolMaxAge:         option.Duration(2 * time.Hour),
+		SpoolExporters:      "test",
+		ExporterOptions: map[string]any{
+			"test": &testOptions{Timeout: option.Duration(time.Second)},
+		},
 	}
 
 	// Here makes a expected Config value.
@@ -171,8 +251,8 @@ func TestConfig_Merge(t *testing.T) {
 	want := opts.ToConfig()
 	want.CollectorConfig.DSN = c.CollectorConfig.DSN
 	want.CollectorConfig.DefaultService = c.CollectorConfig.DefaultService
-	want.MackerelAPIKey = c.MackerelAPIKey
-	want.MackerelAPIBase = c.MackerelAPIBase
+	// Zero fields of exporter options should keep original values.
+	want.ExporterOptions["test"] = &testOptions{Address: "localhost:1234", Verbose: true, Timeout: option.Duration(time.Second)}
 	// Special case: If there are any boolean flags, they should keeps original value because new value, false, is zero.
 	// want.Xxx = true
 
@@ -184,20 +264,87 @@ func TestConfig_Merge(t *testing.T) {
 
 func TestParse(t *testing.T) {
 	const (
-		dsn    = "host=127.1 port=123 user=root"
-		apiKey = "a12345"
+		dsn     = "host=127.1 port=123 user=root"
+		address = "example.com:5678"
+		apiKey  = "a12345"
 	)
 
-	t.Setenv("MACKEREL_APIKEY", apiKey)
-	c, err := Parse("", []string{"-dsn", dsn})
-	if err != nil {
-		t.Errorf("Parse: %v", err)
-		return
+	testCases := map[string]struct {
+		env        map[string]string
+		args       []string
+		wantTest   *testOptions
+		wantAPIKey string
+	}{
+		"test_driver": {
+			env:      map[string]string{"TEST_ADDRESS": address},
+			args:     []string{"-test-timeout", "5s"},
+			wantTest: &testOptions{Address: address, Timeout: option.Duration(5 * time.Second)},
+		},
+		"mackerel_apikey_env": {
+			env:        map[string]string{"MACKEREL_APIKEY": apiKey},
+			wantAPIKey: apiKey,
+		},
+		"mackerel_apikey_flag": {
+			env:        map[string]string{"MACKEREL_APIKEY": "overridden"},
+			args:       []string{"-mackerel-apikey", apiKey},
+			wantAPIKey: apiKey,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			c, err := option.Parse("", append([]string{"-dsn", dsn}, tc.args...))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if s := c.DSNRef; s != dsn {
+				t.Errorf("DSNRef = %s; want %s", s, dsn)
+			}
+			want := tc.wantTest
+			if want == nil {
+				want = &testOptions{Address: "localhost:1234"}
+			}
+			if o := c.ExporterOptions["test"]; !reflect.DeepEqual(o, want) {
+				t.Errorf("ExporterOptions[test] = %+v; want %+v", o, want)
+			}
+			o, ok := c.ExporterOptions[mackerel.Name].(*mackereldriver.Options)
+			if !ok {
+				t.Fatalf("ExporterOptions[%s] = %T; want *mackerel.Options", mackerel.Name, c.ExporterOptions[mackerel.Name])
+			}
+			if o.APIKeyRef != tc.wantAPIKey {
+				t.Errorf("APIKeyRef = %s; want %s", o.APIKeyRef, tc.wantAPIKey)
+			}
+		})
+	}
+}
+
+func TestHandlerOptions_UnmarshalJSON(t *testing.T) {
+	var opts option.HandlerOptions
+	if err := json.Unmarshal([]byte(`{"max-rows": 10, "test-timeout": "1m30s"}`), &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := 10; opts.MaxRows != want {
+		t.Errorf("MaxRows = %d; want %d", opts.MaxRows, want)
+	}
+	// Default values should not be set because Merge treats them as updates.
+	want := &testOptions{Timeout: option.Duration(90 * time.Second)}
+	if o := opts.ExporterOptions["test"]; !reflect.DeepEqual(o, want) {
+		t.Errorf("ExporterOptions[test] = %+v; want %+v", o, want)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	got := option.ParseHeaders("Authorization=Bearer x=y, x-api-key = 123,")
+	want := map[string]string{
+		"Authorization": "Bearer x=y",
+		"x-api-key":     "123",
 	}
-	if s := c.DSNRef; s != dsn {
-		t.Errorf("DSNRef = %s; want %s", s, dsn)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseHeaders() = %v; want %v", got, want)
 	}
-	if s := c.MackerelAPIKeyRef; s != apiKey {
-		t.Errorf("MackerelAPIKeyRef = %s; want %s", s, apiKey)
+	if got := option.ParseHeaders(""); got != nil {
+		t.Errorf("ParseHeaders(\"\") = %v; want nil", got)
 	}
 }
@@ -1,43 +1,94 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/fetcher"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
 	"gopkg.in/yaml.v2"
 )
 
-func loadQueryWithContext(ctx context.Context, path string) ([]query.Query, error) {
-	var err error
-
-	u, err := url.Parse(path)
+func loadQueryWithContext(ctx context.Context, conf *option.Config) ([]query.Query, error) {
+	u, err := url.Parse(conf.QueryFilePath)
 	if err != nil {
 		return nil, err
 	}
 
-	var data []byte
-	if u.Path == "" {
-		data, err = io.ReadAll(os.Stdin)
-	} else {
-		data, err = fetcher.FetchWithContext(ctx, u)
-	}
+	l := queryLoader{env: conf.QueryEnv}
+	queries, err := l.load(ctx, u)
 	if err != nil {
 		return nil, err
 	}
-
-	queries, err := buildValuekeyQueriesFromYAMLString(data)
+	for _, q := range queries {
+		if err := q.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: query %s: %w", q.Source, q.GetName(), err)
+		}
+	}
+	sel := querySelector{
+		Only:     conf.Only,
+		Tags:     conf.Tags,
+		SkipTags: conf.SkipTags,
+	}
+	queries, err = sel.selectQueries(queries)
 	if err != nil {
 		return nil, err
 	}
+	for _, q := range queries {
+		if q.MaxRows == 0 {
+			q.MaxRows = conf.MaxRows
+		}
+	}
 
 	return convertToQuery(queries), nil
 }
 
+// querySelector selects queries to run.
+type querySelector struct {
+	Only     []string
+	Tags     []string
+	SkipTags []string
+}
+
+// selectQueries returns enabled queries that match with s.
+// It returns an error if a name in s.Only does not exist.
+func (s *querySelector) selectQueries(queries []*valuekey.Query) ([]*valuekey.Query, error) {
+	for _, name := range s.Only {
+		if !slices.ContainsFunc(queries, func(q *valuekey.Query) bool { return q.GetName() == name }) {
+			return nil, fmt.Errorf("query %s not found", name)
+		}
+	}
+
+	var selected []*valuekey.Query
+	for _, q := range queries {
+		switch {
+		case !q.IsEnabled():
+		case len(s.Only) > 0 && !slices.Contains(s.Only, q.GetName()):
+		case len(s.Tags) > 0 && !q.HasTag(s.Tags...):
+		case q.HasTag(s.SkipTags...):
+		default:
+			selected = append(selected, q)
+		}
+	}
+	return selected, nil
+}
+
 func convertToQuery(vkQueries []*valuekey.Query) []query.Query {
 	queries := make([]query.Query, len(vkQueries))
 	for i, v := range vkQueries {
@@ -46,11 +97,356 @@ func convertToQuery(vkQueries []*valuekey.Query) []query.Query {
 	return queries
 }
 
-func buildValuekeyQueriesFromYAMLString(str []byte) ([]*valuekey.Query, error) {
-	queries := []*valuekey.Query{}
-	err := yaml.Unmarshal(str, &queries)
+// queryLoader loads query files and files included from them.
+type queryLoader struct {
+	// loading is the stack of the files being loaded, to detect include cycles.
+	loading []string
+
+	// env is the allowlist of environment variables expanded in query files.
+	// Query files are not expanded if env is empty.
+	env []string
+
+	// visit is called with the contents and entries of each file parsed successfully, if it is not nil.
+	visit func(name, path string, data []byte, entries []queryEntry)
+}
+
+// queryFileExts are extensions of the files loaded from directories or glob patterns.
+var queryFileExts = []string{".yaml", ".yml", ".json", ".toml"}
+
+func (l *queryLoader) load(ctx context.Context, u *url.URL) ([]*valuekey.Query, error) {
+	if u.Path == "" {
+		return l.loadFile(ctx, u)
+	}
+
+	urls, err := fetcher.ListWithContext(ctx, u)
 	if err != nil {
 		return nil, err
 	}
+	if urls == nil {
+		return l.loadFile(ctx, u)
+	}
+
+	var queries []*valuekey.Query
+	var n int
+	for _, v := range urls {
+		if !slices.Contains(queryFileExts, strings.ToLower(path.Ext(v.Path))) {
+			continue
+		}
+		n++
+		vs, err := l.loadFile(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, vs...)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s: no query files found", u)
+	}
 	return queries, nil
 }
+
+// resolveInclude returns the URL of ref included from base.
+// Relative refs from relative paths or stdin are resolved as file paths
+// because url.ResolveReference makes them absolute.
+func resolveInclude(base, ref *url.URL) *url.URL {
+	if ref.IsAbs() || base.Scheme != "" && path.IsAbs(base.Path) {
+		return base.ResolveReference(ref)
+	}
+	if path.IsAbs(ref.Path) {
+		return ref
+	}
+	return &url.URL{
+		Scheme: base.Scheme,
+		Path:   filepath.ToSlash(filepath.Join(filepath.Dir(filepath.FromSlash(base.Path)), filepath.FromSlash(ref.Path))),
+	}
+}
+
+func (l *queryLoader) loadFile(ctx context.Context, u *url.URL) ([]*valuekey.Query, error) {
+	name := u.String()
+	if i := slices.Index(l.loading, name); i >= 0 {
+		cycle := append(slices.Clone(l.loading[i:]), name)
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+	}
+	l.loading = append(l.loading, name)
+	defer func() {
+		l.loading = l.loading[:len(l.loading)-1]
+	}()
+
+	var data []byte
+	var err error
+	if u.Path == "" {
+		name = "<stdin>"
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = fetcher.FetchWithContext(ctx, u)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := detectQueryFormat(u.Path).parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(l.env) > 0 {
+		if err := expandQueryEnv(entries, l.env); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if l.visit != nil {
+		l.visit(name, u.Path, data, entries)
+	}
+
+	var queries []*valuekey.Query
+	for _, e := range entries {
+		if e.Include == "" {
+			q := e.Query
+			q.Source = name
+			queries = append(queries, &q)
+			continue
+		}
+		if !reflect.ValueOf(e.Query).IsZero() {
+			return nil, fmt.Errorf("%s: include entry %q must not have other fields", name, e.Include)
+		}
+		ref, err := url.Parse(e.Include)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		included, err := l.load(ctx, resolveInclude(u, ref))
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, included...)
+	}
+	return queries, nil
+}
+
+var (
+	queryEnvRE      = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+	queryEnvParamRE = regexp.MustCompile(`\A\$\{[^}]*\}\z`)
+)
+
+// expandQueryEnv replaces ${VAR} and ${VAR:-default} in string values of entries with the values of environment variables.
+// Only variables that match with allowed can be expanded, and "$${" is replaced with "${".
+// Values are expanded after parsing, so they cannot change the structure of query files.
+// A param that consists of only one variable is converted to a number if the value is a number.
+func expandQueryEnv(entries []queryEntry, allowed []string) error {
+	for i := range entries {
+		if err := expandEnvValue(reflect.ValueOf(&entries[i]).Elem(), allowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandEnvValue expands environment variables in strings held by v. v must be settable.
+func expandEnvValue(v reflect.Value, allowed []string) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := expandEnvString(v.String(), allowed)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return expandEnvValue(v.Elem(), allowed)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		e := reflect.New(v.Elem().Type()).Elem()
+		e.Set(v.Elem())
+		if err := expandEnvValue(e, allowed); err != nil {
+			return err
+		}
+		if e.Kind() == reflect.String && queryEnvParamRE.MatchString(v.Elem().String()) {
+			if n, err := strconv.ParseInt(e.String(), 10, 64); err == nil {
+				e = reflect.ValueOf(n)
+			} else if f, err := strconv.ParseFloat(e.String(), 64); err == nil {
+				e = reflect.ValueOf(f)
+			}
+		}
+		v.Set(e)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := expandEnvValue(v.Field(i), allowed); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandEnvValue(v.Index(i), allowed); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k := reflect.New(v.Type().Key()).Elem()
+			k.Set(iter.Key())
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(iter.Value())
+			if err := expandEnvValue(k, allowed); err != nil {
+				return err
+			}
+			if err := expandEnvValue(e, allowed); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	}
+	return nil
+}
+
+// expandEnvString replaces ${VAR} and ${VAR:-default} in s.
+func expandEnvString(s string, allowed []string) (string, error) {
+	var err error
+	s = queryEnvRE.ReplaceAllStringFunc(s, func(b string) string {
+		m := queryEnvRE.FindStringSubmatch(b)
+		if m[1] == "" {
+			return "${"
+		}
+		name := m[1]
+		if !slices.ContainsFunc(allowed, func(p string) bool {
+			prefix, ok := strings.CutSuffix(p, "*")
+			return name == p || ok && strings.HasPrefix(name, prefix)
+		}) {
+			if err == nil {
+				err = fmt.Errorf("environment variable %s is not allowed to expand", name)
+			}
+			return ""
+		}
+		v, ok := os.LookupEnv(name)
+		if m[2] != "" && v == "" {
+			return m[2][2:]
+		}
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("environment variable %s is not set", name)
+			}
+			return ""
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// queryEntry is an element of query files. It is either a query or an include of other query file.
+type queryEntry struct {
+	Include        string `yaml:"include,omitempty" json:"include,omitempty" toml:"include,omitempty"`
+	valuekey.Query `yaml:",inline"`
+}
+
+// queryFormat is a format of query files.
+type queryFormat string
+
+const (
+	formatYAML queryFormat = "yaml"
+	formatJSON queryFormat = "json"
+	formatTOML queryFormat = "toml"
+)
+
+// detectQueryFormat returns the format of the file.
+// The format is detected by the extension of the file, and defaults to YAML.
+func detectQueryFormat(name string) queryFormat {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return formatJSON
+	case ".toml":
+		return formatTOML
+	case ".yaml", ".yml":
+		return formatYAML
+	}
+	// JSON is mostly a subset of YAML, so files without known extensions are parsed as YAML as before.
+	return formatYAML
+}
+
+func (f queryFormat) parse(data []byte) ([]queryEntry, error) {
+	switch f {
+	case formatJSON:
+		return parseQueryEntriesFromJSON(data)
+	case formatTOML:
+		return parseQueryEntriesFromTOML(data)
+	default:
+		return parseQueryEntriesFromYAML(data)
+	}
+}
+
+// parseQueryEntriesFromYAML parses all documents in str.
+func parseQueryEntriesFromYAML(str []byte) ([]queryEntry, error) {
+	entries := []queryEntry{}
+	dec := yaml.NewDecoder(bytes.NewReader(str))
+	for {
+		var doc []queryEntry
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, doc...)
+	}
+	return entries, nil
+}
+
+// parseQueryEntriesFromJSON parses an array of queries.
+// Numbers in params are decoded as int64 if they are integers, otherwise float64.
+func parseQueryEntriesFromJSON(str []byte) ([]queryEntry, error) {
+	entries := []queryEntry{}
+	dec := json.NewDecoder(bytes.NewReader(str))
+	dec.UseNumber()
+	if err := dec.Decode(&entries); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		params := entries[i].Params
+		for j, p := range params {
+			n, ok := p.(json.Number)
+			if !ok {
+				continue
+			}
+			if v, err := n.Int64(); err == nil {
+				params[j] = v
+				continue
+			}
+			v, err := n.Float64()
+			if err != nil {
+				return nil, err
+			}
+			params[j] = v
+		}
+	}
+	return entries, nil
+}
+
+// tomlQueryFile is the top-level table of TOML query files.
+type tomlQueryFile struct {
+	Queries []queryEntry `toml:"queries"`
+}
+
+// parseQueryEntriesFromTOML parses the array of tables named "queries".
+func parseQueryEntriesFromTOML(str []byte) ([]queryEntry, error) {
+	var f tomlQueryFile
+	if _, err := toml.Decode(string(str), &f); err != nil {
+		return nil, err
+	}
+	if f.Queries == nil {
+		return []queryEntry{}, nil
+	}
+	return f.Queries, nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+)
+
+func writeQueryFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, s := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func queryPrefixes(t *testing.T, path string) []string {
+	t.Helper()
+	u, err := url.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l queryLoader
+	queries, err := l.load(context.Background(), u)
+	if err != nil {
+		t.Fatalf("load(%s): %v", path, err)
+	}
+	prefixes := make([]string, len(queries))
+	for i, q := range queries {
+		prefixes[i] = q.KeyPrefix
+	}
+	return prefixes
+}
+
+func TestQueryLoaderInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"main.yaml": `
+- keyPrefix: main
+- include: teams/a.yaml
+---
+- keyPrefix: second
+- include: file://` + filepath.ToSlash(dir) + `/teams/b.yaml
+`,
+		"teams/a.yaml": `
+- keyPrefix: a
+- include: b.yaml
+`,
+		"teams/b.yaml": `
+- keyPrefix: b
+`,
+	})
+	got := strings.Join(queryPrefixes(t, "file://"+filepath.Join(dir, "main.yaml")), ",")
+	if want := "main,a,b,second,b"; got != want {
+		t.Errorf("load: got %s; want %s", got, want)
+	}
+}
+
+func TestQueryLoaderIncludeRelative(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"q/main.yaml": `
+- keyPrefix: main
+- include: teams/a.yaml
+`,
+		"q/teams/a.yaml": `
+- keyPrefix: a
+- include: ../team.yaml
+`,
+		"q/team.yaml": `
+- keyPrefix: team
+`,
+	})
+	t.Chdir(dir)
+	got := strings.Join(queryPrefixes(t, "q/main.yaml"), ",")
+	if want := "main,a,team"; got != want {
+		t.Errorf("load: got %s; want %s", got, want)
+	}
+}
+
+func TestLoadQuerySeriesOverflow(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"a.yaml": "- name: a\n  maxSeries: 1\n  seriesOverflow: other\n",
+		"b.yaml": "- name: b\n  maxSeries: 1\n  seriesOverflow: bogus\n",
+	})
+	conf := &option.Config{QueryFilePath: "file://" + filepath.Join(dir, "a.yaml")}
+	if _, err := loadQueryWithContext(context.Background(), conf); err != nil {
+		t.Errorf("loadQueryWithContext(a.yaml): got %v", err)
+	}
+	conf.QueryFilePath = "file://" + filepath.Join(dir, "b.yaml")
+	if _, err := loadQueryWithContext(context.Background(), conf); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("loadQueryWithContext(b.yaml): got %v; want an error about bogus", err)
+	}
+}
+
+func TestQueryLoaderIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"a.yaml": "- include: b.yaml\n",
+		"b.yaml": "- include: a.yaml\n",
+	})
+	u := &url.URL{Scheme: "file", Path: filepath.Join(dir, "a.yaml")}
+
+	var l queryLoader
+	_, err := l.load(context.Background(), u)
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("load: got %v; want include cycle error", err)
+	}
+}
+
+func TestQueryLoaderDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"b.yaml":             "- keyPrefix: b\n",
+		"a.yml":              "- keyPrefix: a\n",
+		"c.yaml":             "- keyPrefix: c\n- include: sub/d.yaml\n",
+		"sub/d.yaml":         "- keyPrefix: d\n",
+		"README.md":          "not a query file",
+		"..data/hidden.yaml": "- keyPrefix: hidden\n",
+		"[env]/[e].yaml":     "- keyPrefix: e\n",
+	})
+
+	testCases := map[string]struct {
+		path string
+		want string
+	}{
+		"directory": {
+			path: "file://" + dir,
+			want: "a,b,c,d",
+		},
+		"glob": {
+			path: "file://" + filepath.Join(dir, "*.yaml"),
+			want: "b,c,d",
+		},
+		"include_directory": {
+			path: "file://" + filepath.Join(dir, "sub"),
+			want: "d",
+		},
+		"brackets_directory": {
+			path: "file://" + filepath.Join(dir, "[env]"),
+			want: "e",
+		},
+		"brackets_file": {
+			path: "file://" + filepath.Join(dir, "[env]", "[e].yaml"),
+			want: "e",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got := strings.Join(queryPrefixes(t, tc.path), ",")
+			if got != tc.want {
+				t.Errorf("load: got %s; want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQueryLoaderSource(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"a.yaml": "- keyPrefix: a\n",
+	})
+	u := &url.URL{Scheme: "file", Path: dir}
+
+	var l queryLoader
+	queries, err := l.load(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "file://" + filepath.Join(dir, "a.yaml"); queries[0].Source != want {
+		t.Errorf("Source = %s; want %s", queries[0].Source, want)
+	}
+}
+
+func TestQueryLoaderFormats(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"a.json": `[
+  {"keyPrefix": "a", "valueKey": {"count": "n"}, "params": ["no", 1, 1.5], "command": {"timeout": "5s"}},
+  {"keyPrefix": "a2", "command": {"timeout": 1.5}},
+  {"include": "sub/b.toml"}
+]`,
+		"sub/b.toml": `
+[[queries]]
+keyPrefix = "b"
+params = ["no", 1]
+defaultValue = { "count" = 0 }
+command = { timeout = 2, env = ["PATH"] }
+`,
+		"c.yaml": "- keyPrefix: c\n  params: [no]\n  command: {timeout: 3, dir: /tmp}\n- include: sub/d\n",
+		"sub/d":  "[{keyPrefix: d}]\n",
+	})
+
+	var l queryLoader
+	queries, err := l.load(context.Background(), &url.URL{Scheme: "file", Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*valuekey.Query{
+		{
+			KeyPrefix: "a",
+			ValueKey:  map[string]string{"count": "n"},
+			Params:    []any{"no", int64(1), 1.5},
+			Command:   &valuekey.Command{Timeout: 5 * time.Second},
+		},
+		{
+			KeyPrefix: "a2",
+			Command:   &valuekey.Command{Timeout: 1500 * time.Millisecond},
+		},
+		{
+			KeyPrefix:    "b",
+			Params:       []any{"no", int64(1)},
+			DefaultValue: map[string]float64{"count": 0},
+			Command:      &valuekey.Command{Timeout: 2 * time.Second, Env: []string{"PATH"}},
+		},
+		{
+			KeyPrefix: "c",
+			Params:    []any{false},
+			Command:   &valuekey.Command{Timeout: 3 * time.Second, Dir: "/tmp"},
+		},
+		{
+			KeyPrefix: "d",
+		},
+	}
+	opt := cmpopts.IgnoreFields(valuekey.Query{}, "Source")
+	if diff := cmp.Diff(want, queries, opt); diff != "" {
+		t.Errorf("load: (-want, +got)\n%s", diff)
+	}
+}
+
+func TestExpandQueryEnv(t *testing.T) {
+	t.Setenv("APP_SERVICE", "production")
+	t.Setenv("APP_EMPTY", "")
+	t.Setenv("APP_INJECT", "x\n  sql: DROP TABLE users")
+	t.Setenv("SECRET", "secret")
+	allowed := []string{"APP_*", "THRESHOLD"}
+
+	testCases := map[string]struct {
+		data    string
+		want    []queryEntry
+		wantErr string
+	}{
+		"expand": {
+			data: "- service: ${APP_SERVICE}\n  valueKey: {\"${APP_SERVICE}.count\": n}\n",
+			want: []queryEntry{{Query: valuekey.Query{Service: "production", ValueKey: map[string]string{"production.count": "n"}}}},
+		},
+		"default": {
+			data: "- params:\n  - ${THRESHOLD:-10}\n  - ${APP_EMPTY:-1.5}\n  - a${APP_EMPTY:-x}\n  - ${APP_EMPTY}\n",
+			want: []queryEntry{{Query: valuekey.Query{Params: []any{int64(10), 1.5, "ax", ""}}}},
+		},
+		"escape": {
+			data: "- sql: SELECT $1, $${APP_SERVICE}, $$body$$\n",
+			want: []queryEntry{{Query: valuekey.Query{SQL: "SELECT $1, ${APP_SERVICE}, $$body$$"}}},
+		},
+		"inject": {
+			data: "- service: ${APP_INJECT}\n",
+			want: []queryEntry{{Query: valuekey.Query{Service: "x\n  sql: DROP TABLE users"}}},
+		},
+		"not_allowed": {
+			data:    "- service: ${SECRET}\n",
+			wantErr: "SECRET is not allowed",
+		},
+		"not_set": {
+			data:    "- command: {dir: \"${THRESHOLD}\"}\n",
+			wantErr: "THRESHOLD is not set",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			entries, err := parseQueryEntriesFromYAML([]byte(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = expandQueryEnv(entries, allowed)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expandQueryEnv: got %v; want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandQueryEnv: got %v", err)
+			}
+			if diff := cmp.Diff(tc.want, entries); diff != "" {
+				t.Errorf("expandQueryEnv: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQuerySelector(t *testing.T) {
+	disabled := false
+	queries := []*valuekey.Query{
+		{Name: "a", Tags: []string{"hourly"}},
+		{Name: "b", Tags: []string{"hourly", "expensive"}},
+		{Name: "c", Tags: []string{"daily"}},
+		{Name: "d", Tags: []string{"hourly"}, Enabled: &disabled},
+		{KeyPrefix: "e"},
+	}
+
+	testCases := map[string]struct {
+		sel  querySelector
+		want string
+	}{
+		"all": {
+			want: "a,b,c,e",
+		},
+		"only": {
+			sel:  querySelector{Only: []string{"c", "e", "d"}},
+			want: "c,e",
+		},
+		"tags": {
+			sel:  querySelector{Tags: []string{"hourly"}},
+			want: "a,b",
+		},
+		"skip_tags": {
+			sel:  querySelector{Tags: []string{"hourly"}, SkipTags: []string{"expensive"}},
+			want: "a",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			selected, err := tc.sel.selectQueries(queries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, q := range selected {
+				names = append(names, q.GetName())
+			}
+			if got := strings.Join(names, ","); got != tc.want {
+				t.Errorf("selectQueries: got %s; want %s", got, tc.want)
+			}
+		})
+	}
+
+	sel := querySelector{Only: []string{"x"}}
+	if _, err := sel.selectQueries(queries); err == nil {
+		t.Errorf("selectQueries: want an error for unknown name")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"time"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// writeQuerySchema writes JSON Schema of YAML and JSON query files into w.
+func writeQuerySchema(w io.Writer) error {
+	schema := map[string]any{
+		"$schema": jsonSchemaDraft,
+		"title":   "mackerel-sql-metric-collector queries",
+		"type":    "array",
+		"items":   typeSchema(reflect.TypeOf(queryEntry{})),
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(schema)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// typeSchema returns JSON Schema of t. Fields of structs are named by their yaml tags.
+func typeSchema(t reflect.Type) map[string]any {
+	if t == durationType {
+		return map[string]any{
+			"type":    "string",
+			"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]any{"type": "array"}
+		}
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		for name, f := range yamlFields(t) {
+			props[name] = typeSchema(f.Type)
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/cmd/mackerel-sql-metric-collector/option"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"gopkg.in/yaml.v2"
+)
+
+// validateOptions is used to configure the validate subcommand.
+type validateOptions struct {
+	QueryFilePath string `flag:"query-file" usage:"query file (yaml, json or toml) ^filename^, directory or glob pattern"`
+	QueryEnv      string `flag:"query-env" usage:"comma-separated ^names^ of environment variables expanded in query files; trailing * matches any suffix"`
+	Schema        bool   `flag:"schema" usage:"print JSON Schema of query files instead of validating"`
+}
+
+// errInvalidQuery is returned from runValidate when any problems are found.
+var errInvalidQuery = errors.New("invalid query file")
+
+func runValidate(ctx context.Context, name string, args []string, w io.Writer) error {
+	var opts validateOptions
+	flags, err := option.Flags(name, &opts)
+	if err != nil {
+		return err
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if opts.Schema {
+		return writeQuerySchema(w)
+	}
+
+	u, err := url.Parse(opts.QueryFilePath)
+	if err != nil {
+		return err
+	}
+
+	v := validator{env: option.SplitList(opts.QueryEnv)}
+	problems := v.validate(ctx, u)
+	for _, p := range problems {
+		fmt.Fprintln(w, p) // nolint
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %d problems found", errInvalidQuery, len(problems))
+	}
+	return nil
+}
+
+// problem represents an issue found in query files.
+type problem struct {
+	Source  string
+	Query   int // index of the entry in Source starting at 1, or zero if it is not about an entry
+	Message string
+}
+
+func (p problem) String() string {
+	if p.Query == 0 {
+		return fmt.Sprintf("%s: %s", p.Source, p.Message)
+	}
+	return fmt.Sprintf("%s: query #%d: %s", p.Source, p.Query, p.Message)
+}
+
+// validator checks query files statically.
+type validator struct {
+	// env is the allowlist of environment variables expanded in query files.
+	env []string
+
+	problems []problem
+
+	// metrics holds the first definition of each metric name per service.
+	metrics map[metricKey]definition
+	// names holds the first definition of each query name.
+	names map[string]definition
+	// nqueries is the number of queries validated, to identify each query.
+	nqueries int
+}
+
+type metricKey struct {
+	service string
+	name    string
+}
+
+// definition is the location where a query defines something.
+type definition struct {
+	query  int
+	source string
+	index  int
+}
+
+func (d definition) String() string {
+	return fmt.Sprintf("%s (query #%d)", d.source, d.index)
+}
+
+func (v *validator) validate(ctx context.Context, u *url.URL) []problem {
+	v.metrics = make(map[metricKey]definition)
+	v.names = make(map[string]definition)
+	l := queryLoader{env: v.env, visit: v.validateFile}
+	if _, err := l.load(ctx, u); err != nil {
+		v.problems = append(v.problems, problem{Source: u.String(), Message: err.Error()})
+	}
+	return v.problems
+}
+
+func (v *validator) report(source string, index int, format string, args ...any) {
+	v.problems = append(v.problems, problem{
+		Source:  source,
+		Query:   index,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// validateFile validates entries parsed by the loader from data.
+func (v *validator) validateFile(name, p string, data []byte, entries []queryEntry) {
+	start := len(v.problems)
+	defer func() {
+		slices.SortStableFunc(v.problems[start:], func(a, b problem) int {
+			return a.Query - b.Query
+		})
+	}()
+
+	v.checkFileFields(name, detectQueryFormat(p), data)
+	for i, e := range entries {
+		if e.Include == "" {
+			v.validateQuery(name, i+1, &e.Query)
+		}
+	}
+}
+
+// checkFileFields reports fields in data that are not defined in queryEntry.
+// Decoders of the loader ignore unknown fields, so data is decoded again into generic values in the same format.
+func (v *validator) checkFileFields(name string, f queryFormat, data []byte) {
+	var docs []any
+	switch f {
+	case formatTOML:
+		var file tomlQueryFile
+		md, err := toml.Decode(string(data), &file)
+		if err != nil {
+			v.report(name, 0, "%v", err)
+			return
+		}
+		for _, k := range md.Undecoded() {
+			v.report(name, 0, "unknown field %q", k.String())
+		}
+		return
+	case formatJSON:
+		if err := json.Unmarshal(data, &docs); err != nil {
+			v.report(name, 0, "%v", err)
+			return
+		}
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc []yaml.MapSlice
+			err := dec.Decode(&doc)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				v.report(name, 0, "%v", err)
+				return
+			}
+			for _, m := range doc {
+				docs = append(docs, m)
+			}
+		}
+	}
+	for i, d := range docs {
+		v.checkFields(name, i+1, "", d, reflect.TypeOf(queryEntry{}))
+	}
+}
+
+// checkFields reports keys of the mapping m that are not defined in t.
+func (v *validator) checkFields(name string, index int, prefix string, m any, t reflect.Type) {
+	fields := yamlFields(t)
+	for _, item := range mapItems(m) {
+		f, ok := fields[item.key]
+		if !ok {
+			msg := fmt.Sprintf("unknown field %q", prefix+item.key)
+			for s := range fields {
+				if strings.EqualFold(s, item.key) {
+					msg += fmt.Sprintf(" (did you mean %q?)", prefix+s)
+				}
+			}
+			v.report(name, index, "%s", msg)
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			v.checkFields(name, index, prefix+item.key+".", item.value, ft)
+		}
+	}
+}
+
+type mapItem struct {
+	key   string
+	value any
+}
+
+// mapItems returns items of the mapping m decoded from YAML or JSON.
+// Items of JSON objects are sorted by their keys because the order is not preserved.
+func mapItems(m any) []mapItem {
+	var items []mapItem
+	switch m := m.(type) {
+	case yaml.MapSlice:
+		for _, item := range m {
+			items = append(items, mapItem{key: fmt.Sprint(item.Key), value: item.Value})
+		}
+	case map[string]any:
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			items = append(items, mapItem{key: k, value: m[k]})
+		}
+	}
+	return items
+}
+
+// yamlFields returns fields of t keyed by their names in query files.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func (v *validator) validateQuery(name string, index int, q *valuekey.Query) {
+	v.nqueries++
+	id := v.nqueries
+
+	if q.Name != "" {
+		if d, ok := v.names[q.Name]; ok {
+			v.report(name, index, "query name %q is already used at %s", q.Name, d)
+		} else {
+			v.names[q.Name] = definition{query: id, source: name, index: index}
+		}
+	}
+
+	if q.MaxRows < 0 {
+		v.report(name, index, "maxRows must not be negative")
+	}
+	switch q.MaxRowsPolicy {
+	case "", valuekey.MaxRowsPolicyError, valuekey.MaxRowsPolicyTruncate:
+	default:
+		v.report(name, index, "maxRowsPolicy must be %q or %q", valuekey.MaxRowsPolicyError, valuekey.MaxRowsPolicyTruncate)
+	}
+	if q.MaxSeries < 0 {
+		v.report(name, index, "maxSeries must not be negative")
+	}
+	if err := q.Validate(); err != nil {
+		v.report(name, index, "%v", err)
+	}
+
+	for i, e := range q.Exporters {
+		if !slices.Contains(exporter.Names(), e) {
+			v.report(name, index, "exporters[%d] %q is not a registered exporter", i, e)
+		}
+	}
+
+	if strings.TrimSpace(q.SQL) == "" {
+		v.report(name, index, "sql is empty")
+	}
+
+	cols, hasCols := selectColumns(q.SQL)
+	checkColumn := func(col, what string) {
+		if hasCols && !containsFold(cols, col) {
+			v.report(name, index, "%s refers to column %q that is not in the select list", what, col)
+		}
+	}
+
+	checkName := func(key, field string) {
+		metric := key
+		if q.KeyPrefix != "" {
+			metric = q.KeyPrefix + "." + key
+		}
+		for _, m := range valuekey.ValueKeyRE.FindAllStringSubmatch(key, -1) {
+			checkColumn(m[1], fmt.Sprintf("%s %q", field, key))
+		}
+		s := valuekey.ValueKeyRE.ReplaceAllString(metric, "x")
+		if !valuekey.MetricNameRE.MatchString(s) {
+			msg := fmt.Sprintf("%s %q produces an invalid metric name %q", field, key, metric)
+			if strings.Contains(s, "#{") {
+				msg += "; column names in #{} must match [a-z_]+"
+			}
+			v.report(name, index, "%s", msg)
+		}
+
+		k := metricKey{
+			service: q.Service,
+			name:    valuekey.ValueKeyRE.ReplaceAllString(metric, "#{}"),
+		}
+		if d, ok := v.metrics[k]; ok && d.query != id {
+			v.report(name, index, "metric name %q collides with the query at %s", metric, d)
+		} else if !ok {
+			v.metrics[k] = definition{query: id, source: name, index: index}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(q.ValueKey)) {
+		checkName(key, "valueKey")
+		checkColumn(q.ValueKey[key], fmt.Sprintf("valueKey %q", key))
+	}
+	for _, key := range slices.Sorted(maps.Keys(q.DefaultValue)) {
+		checkName(key, "defaultValue")
+	}
+	if q.Time != "" {
+		checkColumn(q.Time, "time")
+	}
+	if q.TopN != nil {
+		if q.TopN.By == "" {
+			v.report(name, index, "topN.by is required")
+		} else {
+			checkColumn(q.TopN.By, "topN.by")
+		}
+		if q.TopN.Limit <= 0 {
+			v.report(name, index, "topN.limit must be positive")
+		}
+		if o := q.TopN.Others; o != "" && !valuekey.MetricNameRE.MatchString(o) {
+			v.report(name, index, "topN.others %q is not a valid metric name", o)
+		}
+	}
+	if c := q.Check; c != nil {
+		if len(q.ValueKey) > 0 {
+			v.report(name, index, "check cannot be used with valueKey")
+		}
+		if c.Value == "" {
+			v.report(name, index, "check.value is required")
+		} else {
+			checkColumn(c.Value, "check.value")
+		}
+		switch c.Operator {
+		case "", valuekey.CheckOperatorGreater, valuekey.CheckOperatorGreaterEqual, valuekey.CheckOperatorLess, valuekey.CheckOperatorLessEqual:
+		default:
+			v.report(name, index, "check.operator must be %q, %q, %q or %q", valuekey.CheckOperatorGreater, valuekey.CheckOperatorGreaterEqual, valuekey.CheckOperatorLess, valuekey.CheckOperatorLessEqual)
+		}
+		if c.Warning == nil && c.Critical == nil {
+			v.report(name, index, "check needs warning or critical")
+		}
+		for _, m := range valuekey.ValueKeyRE.FindAllStringSubmatch(c.Message, -1) {
+			checkColumn(m[1], "check.message")
+		}
+	}
+
+	v.checkParams(name, index, q)
+}
+
+var (
+	numberedPlaceholderRE = regexp.MustCompile(`(\$|@p)(\d+)\b`)
+	sqlLiteralRE          = regexp.MustCompile(`(?s)'(?:[^']|'')*'|--[^\n]*|/\*.*?\*/`)
+)
+
+// checkParams reports params that are not referred from the SQL, and placeholders that have no params.
+// Only numbered placeholders such as $1 and @p1 are checked,
+// because "?" may also be an operator or a part of identifiers quoted in drivers' own ways.
+func (v *validator) checkParams(name string, index int, q *valuekey.Query) {
+	s := sqlLiteralRE.ReplaceAllString(q.SQL, " ")
+	ms := numberedPlaceholderRE.FindAllStringSubmatch(s, -1)
+	if len(ms) == 0 {
+		return
+	}
+	used := make(map[int]string)
+	for _, m := range ms {
+		i, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		used[i] = m[0]
+	}
+	for i := range q.Params {
+		if _, ok := used[i+1]; !ok {
+			v.report(name, index, "params[%d] is not used; %s%d does not appear in sql", i, ms[0][1], i+1)
+		}
+	}
+	for _, i := range slices.Sorted(maps.Keys(used)) {
+		if i < 1 || i > len(q.Params) {
+			v.report(name, index, "placeholder %s has no corresponding params", used[i])
+		}
+	}
+}
+
+var (
+	selectRE      = regexp.MustCompile(`(?i)\A(?:SELECT)\b\s*(?:(?:DISTINCT|ALL)\b\s*)?`)
+	fromRE        = regexp.MustCompile(`(?i)\A(?:FROM|WHERE|GROUP|ORDER|LIMIT|HAVING|UNION|WINDOW|INTO)\b`)
+	aliasRE       = regexp.MustCompile(`(?i)\bAS\s+("[^"]+"|` + "`[^`]+`" + `|[A-Za-z_][A-Za-z0-9_]*)\s*\z`)
+	identifierRE  = regexp.MustCompile(`\A(?:[A-Za-z_][A-Za-z0-9_]*\.)*("[^"]+"|` + "`[^`]+`" + `|[A-Za-z_][A-Za-z0-9_]*)\z`)
+	implicitAlias = regexp.MustCompile(`[)\s]("[^"]+"|` + "`[^`]+`" + `|[A-Za-z_][A-Za-z0-9_]*)\z`)
+)
+
+// selectColumns returns names of the columns in the top-level select list of sql.
+// It returns false if the names cannot be determined, such as "SELECT *" or expressions without aliases.
+func selectColumns(sql string) ([]string, bool) {
+	s := sqlLiteralRE.ReplaceAllStringFunc(sql, func(m string) string {
+		if strings.HasPrefix(m, "'") {
+			return "''"
+		}
+		return " "
+	})
+
+	// Find the select list at depth 0. CTEs and sub-queries are enclosed by parentheses.
+	depth := 0
+	start := -1
+	var items []string
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth != 0:
+		case start < 0:
+			if (i == 0 || !isIdentChar(s[i-1])) && selectRE.MatchString(s[i:]) {
+				start = i + len(selectRE.FindString(s[i:]))
+				last = start
+				i = start - 1
+			}
+		case c == ',':
+			items = append(items, s[last:i])
+			last = i + 1
+		case !isIdentChar(c) && i+1 < len(s) && fromRE.MatchString(s[i+1:]):
+			items = append(items, s[last:i+1])
+			return columnNames(items)
+		}
+	}
+	if start < 0 {
+		return nil, false
+	}
+	items = append(items, s[last:])
+	return columnNames(items)
+}
+
+func columnNames(items []string) ([]string, bool) {
+	cols := make([]string, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		var m []string
+		for _, re := range []*regexp.Regexp{aliasRE, identifierRE, implicitAlias} {
+			if m = re.FindStringSubmatch(item); m != nil {
+				break
+			}
+		}
+		if m == nil {
+			return nil, false
+		}
+		cols = append(cols, strings.Trim(m[1], "\"`"))
+	}
+	return cols, true
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func containsFold(a []string, s string) bool {
+	for _, v := range a {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
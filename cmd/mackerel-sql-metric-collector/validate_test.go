@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidator(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFiles(t, dir, map[string]string{
+		"a.yaml": `- keyPrefix: users
+  valuekey:
+    count: user_num
+  sql: SELECT COUNT(id) AS user_num FROM users WHERE a = $1
+  params: [1, 2]
+- keyPrefix: users
+  valueKey:
+    status.#{stat}: user_num
+    bad name: user_num
+  defaultValue:
+    status.#{Status}: 0
+  command:
+    timout: 1s
+  sql: |-
+    SELECT status, COUNT(id) AS user_num
+    FROM users
+    WHERE created_at >= ? AND b = '?'
+  topN:
+    by: users
+    limit: 0
+- name: empty
+  keyPrefix: empty
+  sql: ""
+  seriesOverflow: others
+  exporters: [mackerel, vpc]
+- name: lag
+  sql: SELECT lag_seconds FROM replicas
+  check:
+    value: lag
+    operator: "=="
+- include: b.json
+`,
+		"b.json": `[
+  {
+    "name": "empty",
+    "keyPrefix": "users",
+    "valueKey": {"status.#{status}": "n"},
+    "sql": "SELECT status, n FROM t"
+  },
+  {
+    "keyPrefix": "sqlserver",
+    "valueKey": {"n": "n"},
+    "sql": "SELECT n FROM t WHERE a = @p1 AND b = @p3",
+    "params": [1],
+    "comand": {"timeout": "1s"}
+  }
+]`,
+	})
+	a := "file://" + filepath.Join(dir, "a.yaml")
+	b := "file://" + filepath.Join(dir, "b.json")
+
+	var v validator
+	got := v.validate(context.Background(), &url.URL{Scheme: "file", Path: filepath.Join(dir, "a.yaml")})
+	want := []problem{
+		{a, 1, `unknown field "valuekey" (did you mean "valueKey"?)`},
+		{a, 1, `params[1] is not used; $2 does not appear in sql`},
+		{a, 2, `unknown field "command.timout"`},
+		{a, 2, `valueKey "bad name" produces an invalid metric name "users.bad name"`},
+		{a, 2, `valueKey "status.#{stat}" refers to column "stat" that is not in the select list`},
+		{a, 2, `defaultValue "status.#{Status}" produces an invalid metric name "users.status.#{Status}"; column names in #{} must match [a-z_]+`},
+		{a, 2, `topN.by refers to column "users" that is not in the select list`},
+		{a, 2, `topN.limit must be positive`},
+		{a, 3, `seriesOverflow must be "drop", "error" or "other": "others"`},
+		{a, 3, `exporters[1] "vpc" is not a registered exporter`},
+		{a, 3, `sql is empty`},
+		{a, 4, `check.value refers to column "lag" that is not in the select list`},
+		{a, 4, `check.operator must be ">", ">=", "<" or "<="`},
+		{a, 4, `check needs warning or critical`},
+		{b, 1, `query name "empty" is already used at ` + a + ` (query #3)`},
+		{b, 1, `metric name "users.status.#{status}" collides with the query at ` + a + ` (query #2)`},
+		{b, 2, `unknown field "comand"`},
+		{b, 2, `placeholder @p3 has no corresponding params`},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("validate: (-want, +got)\n%s", diff)
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	testCases := map[string]struct {
+		sql  string
+		want []string
+		ok   bool
+	}{
+		"alias": {
+			sql:  "SELECT COUNT(id) AS user_num, t.status, `name`, MAX(x) m FROM t",
+			want: []string{"user_num", "status", "name", "m"},
+			ok:   true,
+		},
+		"cte": {
+			sql:  "WITH a AS (SELECT x, y FROM t) SELECT DISTINCT x AS n FROM a WHERE y = 'FROM'",
+			want: []string{"n"},
+			ok:   true,
+		},
+		"no_from": {
+			sql:  "SELECT 1 AS one, (SELECT 2 FROM t) AS two",
+			want: []string{"one", "two"},
+			ok:   true,
+		},
+		"star": {
+			sql: "SELECT * FROM t",
+		},
+		"no_alias": {
+			sql: "SELECT status, COUNT(*) FROM t GROUP BY status",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, ok := selectColumns(tc.sql)
+			if ok != tc.ok || !slices.Equal(got, tc.want) {
+				t.Errorf("selectColumns(%q) = %q, %t; want %q, %t", tc.sql, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestWriteQuerySchema(t *testing.T) {
+	var w strings.Builder
+	if err := writeQuerySchema(&w); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"keyPrefix"`, `"valueKey"`, `"include"`, `"timeout"`} {
+		if !strings.Contains(w.String(), s) {
+			t.Errorf("schema does not contain %s", s)
+		}
+	}
+}
@@ -3,20 +3,27 @@ package collector
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
 	_ "github.com/lib/pq"              // PostgreSQL driver
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
 	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
 	_ "github.com/mattn/go-sqlite3" // SQLite3 driver
 	_ "github.com/speee/go-athena"  // AWS Athena driver
 	"golang.org/x/sync/errgroup"
 	_ "gorm.io/driver/bigquery/driver" // BigQuery driver
 )
 
+var nowFunc = time.Now
+
 // Collector represents ...
 type Collector struct {
 	config   *Config
@@ -26,6 +33,15 @@ type Collector struct {
 
 // NewCollector is ...
 func NewCollector(conf *Config, exporter exporter.Exporter, logger logr.Logger) (*Collector, error) {
+	switch conf.SeriesOverflow {
+	case "", SeriesOverflowDrop, SeriesOverflowError:
+	default:
+		// Metric names of all queries have no #{column} to replace, so "other" is supported only per query.
+		return nil, fmt.Errorf("series overflow policy must be %q or %q: %q", SeriesOverflowDrop, SeriesOverflowError, conf.SeriesOverflow)
+	}
+	if conf.Heartbeat != "" && conf.CheckHost == "" {
+		return nil, errors.New("heartbeat needs the host to post check reports")
+	}
 	return &Collector{
 		config:   conf,
 		exporter: exporter,
@@ -39,38 +55,122 @@ func (c *Collector) Run(queries []query.Query) error {
 }
 
 // RunWithContext collect and post metrics with context.Context.
+// If c.config.Heartbeat is set, the result of the run is posted as a check monitoring report at the end.
 func (c *Collector) RunWithContext(ctx context.Context, queries []query.Query) error {
 	db, err := openDataSource(c.config.DSN)
 	if err != nil {
-		return err
+		msg := fmt.Sprintf("failed to connect to the database: %v", err)
+		return errors.Join(err, c.heartbeat(ctx, mackerel.CheckStatusCritical, msg))
 	}
 	defer db.Close() // nolint
 
 	queue := make(chan struct{}, c.config.MaxConcurrency-1)
+	limiter := newSeriesLimiter(c.config.MaxSeries, c.config.SeriesOverflow, len(queries))
 
+	var (
+		mu     sync.Mutex
+		failed []string
+	)
 	eg := &errgroup.Group{} // Create *errgroup.Group as we want to run all queries.
-	for _, q := range queries {
+	for i, q := range queries {
 		queue <- struct{}{}
-		q := q
 		eg.Go(func() error {
 			defer func() {
 				<-queue
 			}()
-			metrics, err := q.ExecuteWithContext(ctx, db, c.logger)
+			defer limiter.done(i)
+			err := c.run(ctx, db, limiter, i, q)
 			if err != nil {
-				return err
+				mu.Lock()
+				failed = append(failed, queryName(i, q))
+				mu.Unlock()
 			}
-			return c.exporter.ExportWithContext(ctx, c.detectService(q), metrics)
+			return err
 		})
 	}
+	err = eg.Wait()
+	if f, ok := c.exporter.(exporter.Flusher); ok {
+		err = errors.Join(err, f.Flush())
+	}
+
+	status, msg := mackerel.CheckStatusOK, fmt.Sprintf("%d queries succeeded", len(queries))
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		status = mackerel.CheckStatusWarning
+		msg = fmt.Sprintf("%d of %d queries failed: %s", len(failed), len(queries), strings.Join(failed, ", "))
+	}
+	return errors.Join(err, c.heartbeat(ctx, status, msg))
+}
 
-	if err := eg.Wait(); err != nil {
+func (c *Collector) run(ctx context.Context, db *sql.DB, limiter *seriesLimiter, i int, q query.Query) error {
+	if chk, ok := q.(query.Checker); ok && chk.IsCheck() {
+		return c.check(ctx, db, chk)
+	}
+	metrics, err := q.ExecuteWithContext(ctx, db, c.logger)
+	if err != nil {
 		return err
 	}
+	service := c.detectService(q)
+	metrics, err = limiter.limit(i, q, service, metrics, c.logger)
+	if err != nil {
+		return err
+	}
+	return c.exporter.ExportWithContext(query.NewContext(ctx, q), service, metrics)
+}
+
+// queryName returns the name of q, or its position if q has no names.
+func queryName(i int, q query.Query) string {
+	if name := q.GetName(); name != "" {
+		return name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
 
+// heartbeat posts the result of a run as a check monitoring report if c.config.Heartbeat is set.
+func (c *Collector) heartbeat(ctx context.Context, status mackerel.CheckStatus, message string) error {
+	if c.config.Heartbeat == "" {
+		return nil
+	}
+	r, ok := c.exporter.(exporter.CheckReporter)
+	if !ok {
+		return errors.New("heartbeat: exporter cannot post check reports")
+	}
+	report := &mackerel.CheckReport{
+		Source:     mackerel.NewCheckSourceHost(c.config.CheckHost),
+		Name:       c.config.Heartbeat,
+		Status:     status,
+		Message:    query.TruncateCheckMessage(message),
+		OccurredAt: nowFunc().Unix(),
+	}
+	if err := r.PostCheckReportsWithContext(ctx, []*mackerel.CheckReport{report}); err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
 	return nil
 }
 
+// check posts the result of q as a check monitoring report.
+// The report is posted as UNKNOWN if q failed.
+func (c *Collector) check(ctx context.Context, db *sql.DB, q query.Checker) error {
+	r, ok := c.exporter.(exporter.CheckReporter)
+	if !ok {
+		return fmt.Errorf("query %s: exporter cannot post check reports", q.GetName())
+	}
+	report, err := q.CheckWithContext(ctx, db, c.logger)
+	if report == nil {
+		return err
+	}
+	if report.Source == nil {
+		if c.config.CheckHost == "" {
+			return errors.Join(err, fmt.Errorf("query %s: no host to post check reports", q.GetName()))
+		}
+		report.Source = mackerel.NewCheckSourceHost(c.config.CheckHost)
+	}
+	if perr := r.PostCheckReportsWithContext(query.NewContext(ctx, q), []*mackerel.CheckReport{report}); perr != nil {
+		return errors.Join(err, perr)
+	}
+	return err
+}
+
 func (c *Collector) detectService(q query.Query) string {
 	s := q.GetService()
 	if s == "" {
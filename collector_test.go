@@ -1,6 +1,19 @@
 package collector
 
-import "testing"
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/stdr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter/stdout"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
 
 func TestParseDSN(t *testing.T) {
 	testCases := []struct {
@@ -45,3 +58,209 @@ func TestParseDSN(t *testing.T) {
 		}
 	}
 }
+
+func TestCollectorDryRun(t *testing.T) {
+	conf := &Config{
+		DSN:            "sqlite3://file:dryrun?mode=memory",
+		DefaultService: "Service1",
+		MaxConcurrency: 1,
+	}
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	c, err := NewCollector(conf, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []query.Query{
+		&valuekey.Query{
+			Name:      "users",
+			KeyPrefix: "users",
+			ValueKey: map[string]string{
+				"status.#{status}": "n",
+			},
+			DefaultValue: map[string]float64{
+				"status.active": 0,
+			},
+			SQL:    "SELECT 'active' AS status, ? AS n",
+			Params: []any{1},
+		},
+		&valuekey.Query{
+			Name: "broken",
+			SQL:  "SELEC 1",
+		},
+	}
+
+	var w strings.Builder
+	e, err := stdout.NewExporterWithFormat(&w, stdout.FormatTSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.DryRun(queries, e)
+	if err == nil || !strings.Contains(err.Error(), "query broken") {
+		t.Errorf("DryRun: got %v; want an error of broken query", err)
+	}
+	for _, s := range []string{
+		"query: users\nservice: Service1\nmetrics:\n\tusers.status.#{status}\n\tusers.status.active\nplan:\n",
+		"query: broken\nservice: Service1\nmetrics:\nerror: ",
+	} {
+		if !strings.Contains(w.String(), s) {
+			t.Errorf("DryRun: output does not contain %q:\n%s", s, w.String())
+		}
+	}
+}
+
+type testReporter struct {
+	reports []*mackerel.CheckReport
+}
+
+func (e *testReporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+func (e *testReporter) ExportWithContext(context.Context, string, []*mackerel.MetricValue) error {
+	return nil
+}
+
+func (e *testReporter) PostCheckReportsWithContext(_ context.Context, reports []*mackerel.CheckReport) error {
+	e.reports = append(e.reports, reports...)
+	return nil
+}
+
+func TestCollectorRunHeartbeat(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	queries := []query.Query{
+		&valuekey.Query{
+			Name:     "users",
+			ValueKey: map[string]string{"users": "n"},
+			SQL:      "SELECT 1 AS n",
+		},
+		&valuekey.Query{
+			Name: "broken",
+			SQL:  "SELEC 1",
+		},
+	}
+	testCases := map[string]struct {
+		dsn     string
+		queries []query.Query
+		status  mackerel.CheckStatus
+		message string
+	}{
+		"ok": {
+			dsn:     "sqlite3://file:heartbeat?mode=memory",
+			queries: queries[:1],
+			status:  mackerel.CheckStatusOK,
+			message: "1 queries succeeded",
+		},
+		"partial failure": {
+			dsn:     "sqlite3://file:heartbeat?mode=memory",
+			queries: queries,
+			status:  mackerel.CheckStatusWarning,
+			message: "1 of 2 queries failed: broken",
+		},
+		"unreachable": {
+			dsn:     "unknown://heartbeat",
+			queries: queries,
+			status:  mackerel.CheckStatusCritical,
+			message: "failed to connect to the database: ",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			conf := &Config{
+				DSN:            tc.dsn,
+				MaxConcurrency: 2,
+				CheckHost:      "host1",
+				Heartbeat:      "collector",
+			}
+			var e testReporter
+			c, err := NewCollector(conf, &e, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.RunWithContext(context.Background(), tc.queries)
+			if (err != nil) != (tc.status != mackerel.CheckStatusOK) {
+				t.Errorf("RunWithContext: got %v", err)
+			}
+			if len(e.reports) != 1 {
+				t.Fatalf("RunWithContext: posted %d reports; want 1", len(e.reports))
+			}
+			r := e.reports[0]
+			if r.Name != "collector" || r.Status != tc.status || !strings.HasPrefix(r.Message, tc.message) {
+				t.Errorf("RunWithContext: got %s %s %q; want collector %s %q", r.Name, r.Status, r.Message, tc.status, tc.message)
+			}
+		})
+	}
+}
+
+func TestNewCollectorSeriesOverflow(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	for _, policy := range []string{"", SeriesOverflowDrop, SeriesOverflowError} {
+		if _, err := NewCollector(&Config{SeriesOverflow: policy}, nil, logger); err != nil {
+			t.Errorf("NewCollector(%q): got %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"other", "bogus"} {
+		if _, err := NewCollector(&Config{SeriesOverflow: policy}, nil, logger); err == nil {
+			t.Errorf("NewCollector(%q): want an error", policy)
+		}
+	}
+}
+
+func TestSeriesLimiter(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	q := &valuekey.Query{Name: "q"}
+	metrics := func(names ...string) []*mackerel.MetricValue {
+		a := make([]*mackerel.MetricValue, len(names))
+		for i, name := range names {
+			a[i] = &mackerel.MetricValue{Name: name, Value: int64(1), Time: 100}
+		}
+		return a
+	}
+
+	l := newSeriesLimiter(2, SeriesOverflowDrop, 2)
+	got, err := l.limit(0, q, "s1", metrics("a", "b"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("limit: got %d metrics; want 2", len(got))
+	}
+	got, err = l.limit(1, q, "s1", metrics("a", "c", "d", "sql_metric_collector.overflow_series.q"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*mackerel.MetricValue{
+		{Name: "a", Value: int64(1), Time: 100},
+		{Name: "sql_metric_collector.overflow_series.q", Value: int64(3), Time: 100},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("limit: (-want, +got)\n%s", diff)
+	}
+
+	l = newSeriesLimiter(1, SeriesOverflowError, 2)
+	if _, err := l.limit(0, q, "s1", metrics("a"), logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.limit(1, q, "s2", metrics("a"), logger); err == nil {
+		t.Errorf("limit: want an error")
+	}
+}
+
+func TestSeriesLimiterOrder(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	q := &valuekey.Query{Name: "q"}
+	l := newSeriesLimiter(1, SeriesOverflowError, 3)
+
+	// The third query finishes first, but series of the second query are admitted first.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := l.limit(2, q, "s1", []*mackerel.MetricValue{{Name: "c", Value: int64(1)}}, logger)
+		errc <- err
+	}()
+	l.done(0) // The first query failed.
+	if _, err := l.limit(1, q, "s1", []*mackerel.MetricValue{{Name: "b", Value: int64(1)}}, logger); err != nil {
+		t.Errorf("limit(1): got %v", err)
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("limit(2): want an error")
+	}
+}
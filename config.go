@@ -5,4 +5,17 @@ type Config struct {
 	DSN            string
 	DefaultService string
 	MaxConcurrency int
+
+	// MaxSeries limits the number of series posted in a run. Zero means unlimited.
+	// Series are admitted in the order of queries, so metrics of a query are exported after the preceding queries.
+	MaxSeries int
+	// SeriesOverflow is SeriesOverflowDrop or SeriesOverflowError.
+	SeriesOverflow string
+
+	// CheckHost is the host ID that check reports belong to unless queries specify their host.
+	CheckHost string
+
+	// Heartbeat is the name of the check monitoring that reports the result of each run. Empty disables it.
+	// The report is posted to CheckHost.
+	Heartbeat string
 }
@@ -0,0 +1,175 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"google.golang.org/api/option"
+)
+
+// explainer returns the plan of stmt.
+type explainer func(ctx context.Context, db *sql.DB, dsn, stmt string, params []any) ([]string, error)
+
+// explainers holds functions that return the plan of the statement for each driver.
+var explainers = map[string]explainer{
+	"postgres":         explainWithPrefix("EXPLAIN "),
+	"mysql":            explainWithPrefix("EXPLAIN "),
+	"sqlite3":          explainWithPrefix("EXPLAIN QUERY PLAN "),
+	"athena":           explainWithPrefix("EXPLAIN "),
+	bigQueryDriverName: explainBigQuery,
+}
+
+// DryRun explains queries and writes metric names that would be posted into w.
+func (c *Collector) DryRun(queries []query.Query, w exporter.PlanWriter) error {
+	return c.DryRunWithContext(context.Background(), queries, w)
+}
+
+// DryRunWithContext explains queries and writes metric names that would be posted into w, with context.Context.
+// It neither executes queries nor exports metrics. w is flushed at the end if it is an exporter.Flusher.
+func (c *Collector) DryRunWithContext(ctx context.Context, queries []query.Query, w exporter.PlanWriter) error {
+	driverName, dataSourceName, err := parseDSN(c.config.DSN)
+	if err != nil {
+		return err
+	}
+	explain, ok := explainers[driverName]
+	if !ok {
+		return fmt.Errorf("%s: dry-run is not supported", driverName)
+	}
+
+	db, err := openDataSource(c.config.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close() // nolint
+
+	var errs []error
+	for _, q := range queries {
+		p := &exporter.Plan{
+			Query:   q.GetName(),
+			Service: c.detectService(q),
+		}
+		if r, ok := q.(query.Router); ok {
+			p.Exporters = r.GetExporters()
+		}
+
+		err := c.explain(ctx, db, dataSourceName, explain, q, p)
+		if err != nil {
+			p.Error = err.Error()
+			errs = append(errs, fmt.Errorf("query %s: %w", q.GetName(), err))
+		}
+		if err := w.WritePlan(p); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+	if f, ok := w.(exporter.Flusher); ok {
+		errs = append(errs, f.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// explain fills p with metric names and the plan of q.
+func (c *Collector) explain(ctx context.Context, db *sql.DB, dsn string, explain explainer, q query.Query, p *exporter.Plan) error {
+	s, ok := q.(query.Statement)
+	if !ok {
+		return errors.New("dry-run is not supported")
+	}
+	if chk, ok := q.(query.Checker); ok && chk.IsCheck() {
+		p.Check = chk.CheckName()
+	} else {
+		p.Metrics = s.MetricNames()
+	}
+
+	stmt, params, err := s.StatementWithContext(ctx, c.logger)
+	if err != nil {
+		return err
+	}
+	p.Plan, err = explain(ctx, db, dsn, stmt, params)
+	return err
+}
+
+// explainWithPrefix returns the function that runs stmt prefixed with prefix, then returns its rows as lines.
+func explainWithPrefix(prefix string) explainer {
+	return func(ctx context.Context, db *sql.DB, _, stmt string, params []any) ([]string, error) {
+		rows, err := db.QueryContext(ctx, prefix+stmt, params...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close() // nolint
+
+		cols, err := rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+
+		var lines []string
+		for rows.Next() {
+			row := make([]sql.NullString, len(cols))
+			rowp := make([]any, len(cols))
+			for i := range row {
+				rowp[i] = &row[i]
+			}
+			if err := rows.Scan(rowp...); err != nil {
+				return nil, err
+			}
+			fields := make([]string, len(row))
+			for i, v := range row {
+				fields[i] = v.String
+			}
+			lines = append(lines, strings.Join(fields, "\t"))
+		}
+		return lines, rows.Err()
+	}
+}
+
+// explainBigQuery runs stmt as a dry-run job because BigQuery does not support EXPLAIN.
+// dsn is formatted as "bigquery://project/location/dataset" or "bigquery://project/dataset".
+func explainBigQuery(ctx context.Context, _ *sql.DB, dsn, stmt string, params []any) ([]string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+
+	var opts []option.ClientOption
+	if s := u.Query().Get("endpoint"); s != "" {
+		opts = append(opts, option.WithEndpoint(s))
+	}
+	if u.Query().Get("disable_auth") == "true" {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	client, err := bigquery.NewClient(ctx, u.Hostname(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close() // nolint
+
+	q := client.Query(stmt)
+	q.DryRun = true
+	q.DefaultProjectID = u.Hostname()
+	q.DefaultDatasetID = fields[len(fields)-1]
+	if len(fields) == 2 {
+		q.Location = fields[0]
+	}
+	for _, p := range params {
+		q.Parameters = append(q.Parameters, bigquery.QueryParameter{Value: p})
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := job.LastStatus()
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return []string{
+		fmt.Sprintf("total bytes processed: %d", status.Statistics.TotalBytesProcessed),
+	}, nil
+}
@@ -2,6 +2,7 @@ package exporter
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/mackerelio/mackerel-client-go"
 )
@@ -11,3 +12,61 @@ type Exporter interface {
 	Export(string, []*mackerel.MetricValue) error
 	ExportWithContext(context.Context, string, []*mackerel.MetricValue) error
 }
+
+// CheckReporter is implemented by exporters that can post check monitoring reports.
+type CheckReporter interface {
+	PostCheckReportsWithContext(context.Context, []*mackerel.CheckReport) error
+}
+
+// Flusher is implemented by exporters that buffer metrics until the end of each run.
+type Flusher interface {
+	Flush() error
+}
+
+// HTTPError is an error response from an HTTP endpoint.
+type HTTPError struct {
+	Exporter   string
+	Status     string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Exporter, e.Status, e.Body)
+}
+
+// Plan is the result of a dry run of a query.
+type Plan struct {
+	Query     string   `json:"query"`
+	Service   string   `json:"service"`
+	Exporters []string `json:"exporters,omitempty"`
+	Check     string   `json:"check,omitempty"`
+	Metrics   []string `json:"metrics,omitempty"`
+	Plan      []string `json:"plan,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// PlanWriter is implemented by exporters that can print results of dry runs.
+type PlanWriter interface {
+	WritePlan(*Plan) error
+}
+
+// ToFloat64 returns the value of m as float64.
+func ToFloat64(m *mackerel.MetricValue) (float64, error) {
+	switch v := m.Value.(type) {
+	case int32:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case int64: // rounded
+		return float64(v), nil
+	case uint64: // rounded
+		return float64(v), nil
+	case float64:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("invalid metric.Value: key = %s, metric.Value = (%T)%v", m.Name, m.Value, m.Value)
+	}
+}
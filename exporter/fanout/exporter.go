@@ -0,0 +1,156 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Backend is an exporter that receives every batch.
+type Backend struct {
+	Name     string
+	Exporter exporter.Exporter
+
+	// BestEffort makes failures of the backend logged instead of returned.
+	BestEffort bool
+}
+
+// Exporter sends each batch to all backends concurrently.
+type Exporter struct {
+	backends []*Backend
+	logger   logr.Logger
+}
+
+// NewExporter is ...
+func NewExporter(backends []*Backend, logger logr.Logger) *Exporter {
+	return &Exporter{
+		backends: backends,
+		logger:   logger,
+	}
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// ExportWithContext returns errors of required backends after all backends finish.
+// If the query in ctx implements query.Router, metrics are sent only to the backends it names.
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	names := routes(ctx)
+	errs := make([]error, len(e.backends))
+	var wg sync.WaitGroup
+	for i, b := range e.backends {
+		if len(names) > 0 && !slices.Contains(names, b.Name) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := b.Exporter.ExportWithContext(ctx, service, metrics)
+			if err == nil {
+				return
+			}
+			if b.BestEffort {
+				e.logger.Error(err, "failed to export metrics", "exporter", b.Name, "service", service)
+				return
+			}
+			errs[i] = fmt.Errorf("%s: %w", b.Name, err)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// PostCheckReportsWithContext posts reports through backends that implement exporter.CheckReporter.
+// Like ExportWithContext, it respects routes of the query in ctx.
+// It returns an error if no backends can post reports.
+func (e *Exporter) PostCheckReportsWithContext(ctx context.Context, reports []*mackerel.CheckReport) error {
+	names := routes(ctx)
+	var (
+		errs []error
+		sent bool
+	)
+	for _, b := range e.backends {
+		if len(names) > 0 && !slices.Contains(names, b.Name) {
+			continue
+		}
+		r, ok := as[exporter.CheckReporter](b.Exporter)
+		if !ok {
+			continue
+		}
+		sent = true
+		if err := r.PostCheckReportsWithContext(ctx, reports); err != nil {
+			if b.BestEffort {
+				e.logger.Error(err, "failed to post check reports", "exporter", b.Name)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		}
+	}
+	if !sent {
+		return errors.New("no exporters can post check reports")
+	}
+	return errors.Join(errs...)
+}
+
+// Flush flushes all backends that implement exporter.Flusher.
+func (e *Exporter) Flush() error {
+	var errs []error
+	for _, b := range e.backends {
+		f, ok := as[exporter.Flusher](b.Exporter)
+		if !ok {
+			continue
+		}
+		if err := f.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// as returns e, or the exporter wrapped by e, as T.
+func as[T any](e exporter.Exporter) (T, bool) {
+	for {
+		if v, ok := e.(T); ok {
+			return v, true
+		}
+		w, ok := e.(interface{ Unwrap() exporter.Exporter })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		e = w.Unwrap()
+	}
+}
+
+// routes returns exporters named by the query in ctx.
+func routes(ctx context.Context) []string {
+	if q, ok := query.FromContext(ctx); ok {
+		if r, ok := q.(query.Router); ok {
+			return r.GetExporters()
+		}
+	}
+	return nil
+}
+
+// Close closes all backends that implement io.Closer.
+func (e *Exporter) Close() error {
+	var errs []error
+	for _, b := range e.backends {
+		if c, ok := b.Exporter.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
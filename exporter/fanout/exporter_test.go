@@ -0,0 +1,121 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/go-logr/stdr"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/internal/exportertest"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestExporterExport(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	errRequired := errors.New("required")
+	a := &exportertest.Exporter{}
+	b := &exportertest.Exporter{Err: errors.New("best-effort")}
+	c := &exportertest.Exporter{Err: errRequired}
+	metrics := []*mackerel.MetricValue{{Name: "a", Value: int64(1), Time: 100}}
+
+	e := NewExporter([]*Backend{
+		{Name: "a", Exporter: a},
+		{Name: "b", Exporter: b, BestEffort: true},
+	}, logger)
+	if err := e.Export("s", metrics); err != nil {
+		t.Errorf("Export: got %v; best-effort errors should be ignored", err)
+	}
+	if a.Calls != 1 || b.Calls != 1 {
+		t.Errorf("Export: all backends should receive metrics")
+	}
+
+	e = NewExporter([]*Backend{
+		{Name: "a", Exporter: a},
+		{Name: "c", Exporter: c},
+	}, logger)
+	if err := e.Export("s", metrics); !errors.Is(err, errRequired) {
+		t.Errorf("Export: got %v; want %v", err, errRequired)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Closed || !c.Closed {
+		t.Errorf("Close: all backends should be closed")
+	}
+}
+
+func TestExporterExport_route(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	a := &exportertest.Exporter{}
+	b := &exportertest.Exporter{}
+	e := NewExporter([]*Backend{
+		{Name: "a", Exporter: a},
+		{Name: "b", Exporter: b},
+	}, logger)
+	metrics := []*mackerel.MetricValue{{Name: "a", Value: int64(1), Time: 100}}
+
+	ctx := exportertest.Context(&valuekey.Query{Exporters: []string{"b"}})
+	if err := e.ExportWithContext(ctx, "s", metrics); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Got) != 0 || len(b.Got) != 1 {
+		t.Errorf("ExportWithContext: got a=%v, b=%v; want only b", a.Got, b.Got)
+	}
+
+	ctx = exportertest.Context(&valuekey.Query{})
+	if err := e.ExportWithContext(ctx, "s", metrics); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Got) != 1 {
+		t.Errorf("ExportWithContext: queries without exporters should be sent to all backends")
+	}
+}
+
+type testReporter struct {
+	exportertest.Exporter
+	reports []*mackerel.CheckReport
+}
+
+func (e *testReporter) PostCheckReportsWithContext(_ context.Context, reports []*mackerel.CheckReport) error {
+	e.reports = reports
+	return e.Err
+}
+
+// testWrapper wraps an exporter like spool.Exporter.
+type testWrapper struct {
+	exportertest.Exporter
+	e exporter.Exporter
+}
+
+func (w *testWrapper) Unwrap() exporter.Exporter {
+	return w.e
+}
+
+func TestExporterPostCheckReports(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	a := &testReporter{}
+	b := &testReporter{}
+	e := NewExporter([]*Backend{
+		{Name: "a", Exporter: &testWrapper{e: a}},
+		{Name: "b", Exporter: b},
+		{Name: "c", Exporter: &exportertest.Exporter{}},
+	}, logger)
+	reports := []*mackerel.CheckReport{{Name: "check", Status: mackerel.CheckStatusOK}}
+
+	ctx := exportertest.Context(&valuekey.Query{Exporters: []string{"a", "c"}})
+	if err := e.PostCheckReportsWithContext(ctx, reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.reports) != 1 || len(b.reports) != 0 {
+		t.Errorf("PostCheckReportsWithContext: got a=%v, b=%v; want only a", a.reports, b.reports)
+	}
+
+	ctx = exportertest.Context(&valuekey.Query{Exporters: []string{"c"}})
+	if err := e.PostCheckReportsWithContext(ctx, reports); err == nil {
+		t.Errorf("PostCheckReportsWithContext: want an error because c cannot post check reports")
+	}
+}
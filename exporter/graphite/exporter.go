@@ -0,0 +1,144 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Name defines this exporter name.
+const Name = "graphite"
+
+const (
+	defaultAddress      = "localhost:2003"
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// Config represents the configuration of the exporter.
+type Config struct {
+	// Address is "host:port" of the Carbon plaintext receiver.
+	Address string
+
+	// Prefix is prepended to each path before the service.
+	Prefix string
+
+	// WriteTimeout limits each write. Zero means 10 seconds.
+	WriteTimeout time.Duration
+}
+
+// Exporter writes metrics in the Graphite plaintext protocol.
+type Exporter struct {
+	config *Config
+	dial   func(ctx context.Context) (net.Conn, error)
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewExporter is ...
+func NewExporter(conf *Config) *Exporter {
+	addr := conf.Address
+	if addr == "" {
+		addr = defaultAddress
+	}
+	var d net.Dialer
+	return &Exporter{
+		config: conf,
+		dial: func(ctx context.Context) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		},
+	}
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// ExportWithContext writes metrics under the path of the service.
+// If the connection is broken, it reconnects and writes metrics again once.
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	prefix := e.config.Prefix
+	if prefix != "" && prefix[len(prefix)-1] != '.' {
+		prefix += "."
+	}
+	prefix += pathComponent(service) + "."
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		v, err := exporter.ToFloat64(m)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s%s %s %d\n", prefix, m.Name, strconv.FormatFloat(v, 'f', -1, 64), m.Time) // nolint
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err := e.write(ctx, buf.Bytes())
+	if err == nil {
+		return nil
+	}
+	e.closeConn()
+	if err := e.write(ctx, buf.Bytes()); err != nil {
+		e.closeConn()
+		return fmt.Errorf("graphite: %w", err)
+	}
+	return nil
+}
+
+func (e *Exporter) write(ctx context.Context, p []byte) error {
+	if e.conn == nil {
+		conn, err := e.dial(ctx)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	timeout := e.config.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultWriteTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := e.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	_, err := e.conn.Write(p)
+	return err
+}
+
+func (e *Exporter) closeConn() {
+	if e.conn != nil {
+		e.conn.Close() // nolint
+		e.conn = nil
+	}
+}
+
+// Close closes the connection.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closeConn()
+	return nil
+}
+
+var invalidPathCharsRE = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// pathComponent converts s into a single component of Graphite paths.
+func pathComponent(s string) string {
+	return invalidPathCharsRE.ReplaceAllString(s, "_")
+}
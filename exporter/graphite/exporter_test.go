@@ -0,0 +1,60 @@
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestExporterExport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ln.Close() // nolint
+	})
+	lines := make(chan string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() // nolint
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+
+	e := NewExporter(&Config{Address: ln.Addr().String(), Prefix: "sql"})
+	t.Cleanup(func() {
+		e.Close() // nolint
+	})
+	metrics := []*mackerel.MetricValue{
+		{Name: "users.count", Value: int64(10), Time: 100},
+		{Name: "users.ratio", Value: 0.5, Time: 100},
+	}
+	want := []string{
+		"sql.my_service.users.count 10 100",
+		"sql.my_service.users.ratio 0.5 100",
+	}
+	for i := range 2 {
+		if err := e.Export("my.service", metrics); err != nil {
+			t.Fatalf("Export #%d: %v", i, err)
+		}
+		for _, w := range want {
+			if got := <-lines; got != w {
+				t.Errorf("Export #%d: got %q; want %q", i, got, w)
+			}
+		}
+		// Break the connection to check whether the exporter reconnects.
+		e.conn.Close() // nolint
+	}
+}
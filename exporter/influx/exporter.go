@@ -0,0 +1,197 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Name defines this exporter name.
+const Name = "influx"
+
+const (
+	defaultTimeout = 10 * time.Second
+
+	// defaultField is the field key of metrics whose names have no dots.
+	defaultField = "value"
+)
+
+// Config represents the configuration of the exporter.
+type Config struct {
+	// URL is the base URL of InfluxDB such as "http://localhost:8086", or "file:///path/to/file" to append lines to the file.
+	URL string
+
+	// Org, Bucket and Token are parameters of the v2 write API.
+	Org    string
+	Bucket string
+	Token  string
+
+	// Timeout limits each write over HTTP. Zero means 10 seconds.
+	Timeout time.Duration
+}
+
+// Exporter writes metrics in the InfluxDB line protocol.
+type Exporter struct {
+	write func(ctx context.Context, p []byte) error
+	close func() error
+}
+
+// NewExporter is ...
+func NewExporter(conf *Config) (*Exporter, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		f, err := os.OpenFile(u.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		var mu sync.Mutex
+		return &Exporter{
+			write: func(_ context.Context, p []byte) error {
+				mu.Lock()
+				defer mu.Unlock()
+				_, err := f.Write(p)
+				return err
+			},
+			close: f.Close,
+		}, nil
+	case "http", "https":
+		u = u.JoinPath("api/v2/write")
+		q := u.Query()
+		q.Set("org", conf.Org)
+		q.Set("bucket", conf.Bucket)
+		q.Set("precision", "s")
+		u.RawQuery = q.Encode()
+		timeout := conf.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		client := &http.Client{Timeout: timeout}
+		return &Exporter{
+			write: func(ctx context.Context, p []byte) error {
+				return writeHTTP(ctx, client, u.String(), conf.Token, p)
+			},
+			close: func() error {
+				client.CloseIdleConnections()
+				return nil
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("influx: unsupported URL %q", conf.URL)
+	}
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// keyPrefixer is implemented by queries that know the prefix of their metric names.
+type keyPrefixer interface {
+	GetKeyPrefix() string
+}
+
+// ExportWithContext writes metrics as lines in second precision.
+// The measurement is the key prefix of the query, and fields are the rest of metric names.
+// The service and the query name are written as tags.
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	var prefix string
+	tags := ",service=" + tagReplacer.Replace(service)
+	if q, ok := query.FromContext(ctx); ok {
+		if name := q.GetName(); name != "" {
+			tags += ",query=" + tagReplacer.Replace(name)
+		}
+		if p, ok := q.(keyPrefixer); ok {
+			prefix = p.GetKeyPrefix()
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		measurement, field := split(m.Name, prefix)
+		v, err := formatValue(m)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s%s %s=%s %d\n", measurementReplacer.Replace(measurement), tags, tagReplacer.Replace(field), v, m.Time) // nolint
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return e.write(ctx, buf.Bytes())
+}
+
+// Close closes the file or connections.
+func (e *Exporter) Close() error {
+	return e.close()
+}
+
+// split splits name into the measurement and the field key.
+func split(name, prefix string) (string, string) {
+	if prefix != "" {
+		if s, ok := strings.CutPrefix(name, prefix+"."); ok {
+			return prefix, s
+		}
+	}
+	if m, f, ok := strings.Cut(name, "."); ok {
+		return m, f
+	}
+	return name, defaultField
+}
+
+// formatValue formats the value of m as a float field.
+// Integers are also written as floats because InfluxDB rejects fields whose types change,
+// and the same column may be converted to int64 or float64 depending on its values.
+func formatValue(m *mackerel.MetricValue) (string, error) {
+	v, err := exporter.ToFloat64(m)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64), nil
+}
+
+var (
+	measurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagReplacer         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+func writeHTTP(ctx context.Context, client *http.Client, u, token string, p []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &exporter.HTTPError{
+			Exporter:   "influx",
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Body:       string(bytes.TrimSpace(body)),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package influx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/internal/exportertest"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+var testMetrics = []*mackerel.MetricValue{
+	{Name: "tenants.users.a", Value: int64(10), Time: 100},
+	{Name: "tenants.ratio", Value: 0.5, Time: 100},
+	{Name: "other.count", Value: int64(1), Time: 100},
+	{Name: "total", Value: int64(2), Time: 100},
+}
+
+const wantLines = `tenants,service=my\ service,query=users users.a=10 100
+tenants,service=my\ service,query=users ratio=0.5 100
+other,service=my\ service,query=users count=1 100
+total,service=my\ service,query=users value=2 100
+`
+
+var testQuery = &valuekey.Query{Name: "users", KeyPrefix: "tenants"}
+
+func TestExporterHTTP(t *testing.T) {
+	var got string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v2/write" || q.Get("org") != "myorg" || q.Get("bucket") != "sql" || q.Get("precision") != "s" {
+			http.Error(w, r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Token xxx" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+
+	e, err := NewExporter(&Config{URL: s.URL, Org: "myorg", Bucket: "sql", Token: "xxx"})
+	if err != nil {
+		t.Fatal("NewExporter: ", err)
+	}
+	t.Cleanup(func() {
+		e.Close() // nolint
+	})
+	if err := e.ExportWithContext(exportertest.Context(testQuery), "my service", testMetrics); err != nil {
+		t.Fatal("ExportWithContext: ", err)
+	}
+	if diff := cmp.Diff(wantLines, got); diff != "" {
+		t.Errorf("body: (-want, +got)\n%s", diff)
+	}
+
+	e, err = NewExporter(&Config{URL: s.URL, Org: "myorg", Bucket: "sql", Token: "yyy"})
+	if err != nil {
+		t.Fatal("NewExporter: ", err)
+	}
+	if err := e.ExportWithContext(exportertest.Context(testQuery), "my service", testMetrics); err == nil {
+		t.Error("ExportWithContext: want an error")
+	}
+}
+
+func TestExporterFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "metrics.lp")
+	e, err := NewExporter(&Config{URL: "file://" + name})
+	if err != nil {
+		t.Fatal("NewExporter: ", err)
+	}
+	for range 2 {
+		if err := e.ExportWithContext(exportertest.Context(testQuery), "my service", testMetrics); err != nil {
+			t.Fatal("ExportWithContext: ", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(wantLines+wantLines, string(got)); diff != "" {
+		t.Errorf("file: (-want, +got)\n%s", diff)
+	}
+}
@@ -2,6 +2,12 @@ package mackerel
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/mackerelio/mackerel-client-go"
 )
@@ -11,26 +17,89 @@ const (
 	Name = "mackerel"
 
 	userAgent = "mackerel-sql-metric-collector"
+
+	defaultAPIBase = "https://api.mackerelio.com/"
+	defaultTimeout = 30 * time.Second
 )
 
+// Config represents the configuration of the exporter.
+type Config struct {
+	APIKey string
+
+	// APIBase is the base URL of the Mackerel API. Empty means the default.
+	APIBase string
+
+	// Timeout limits each request. Zero means 30 seconds, same as mackerel.Client.
+	Timeout time.Duration
+
+	// Proxy is the URL of the HTTP proxy. Empty means proxies in environment variables such as HTTPS_PROXY.
+	Proxy string
+
+	// CACert is PEM encoded certificates to verify the server. Empty means the system pool.
+	CACert string
+
+	// Headers are sent with each request.
+	Headers map[string]string
+}
+
 // Exporter represents ...
 type Exporter struct {
-	client *mackerel.Client
+	client  *mackerel.Client
+	timeout time.Duration
 }
 
 // NewExporter is ...
 func NewExporter(apiKey, apiBase string) (*Exporter, error) {
-	client, err := newMackerelClient(apiKey, apiBase)
+	return NewExporterWithConfig(&Config{
+		APIKey:  apiKey,
+		APIBase: apiBase,
+	})
+}
+
+// NewExporterWithConfig returns the exporter configured with conf.
+func NewExporterWithConfig(conf *Config) (*Exporter, error) {
+	apiBase := conf.APIBase
+	if apiBase == "" {
+		apiBase = defaultAPIBase
+	}
+	client, err := mackerel.NewClientWithOptions(conf.APIKey, apiBase, false)
 	if err != nil {
 		return nil, err
 	}
-
 	client.UserAgent = userAgent
+	for k, v := range conf.Headers {
+		client.AdditionalHeaders.Set(k, v)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.Proxy != "" {
+		u, err := url.Parse(conf.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if conf.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(conf.CACert)) {
+			return nil, errors.New("mackerel: no valid certificates in CA cert")
+		}
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    pool,
+		}
+	}
+	// The timeout is applied to the context of each request because contextTransport replaces it.
+	client.HTTPClient = &http.Client{Transport: transport}
 
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
 	return &Exporter{
-		client: client,
+		client:  client,
+		timeout: timeout,
 	}, nil
-
 }
 
 // Export is ...
@@ -40,14 +109,35 @@ func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error
 
 // ExportWithContext is ...
 func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
-	// TODO: mackerel.Client does not support context.
-	return e.client.PostServiceMetricValues(service, metrics)
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.clientWithContext(ctx).PostServiceMetricValues(service, metrics)
 }
 
-func newMackerelClient(apiKey, apiBase string) (*mackerel.Client, error) {
-	if apiBase != "" {
-		return mackerel.NewClientWithOptions(apiKey, apiBase, false)
-	}
+// PostCheckReportsWithContext posts reports as check monitoring results.
+func (e *Exporter) PostCheckReportsWithContext(ctx context.Context, reports []*mackerel.CheckReport) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.clientWithContext(ctx).PostCheckReports(&mackerel.CheckReports{Reports: reports})
+}
+
+// clientWithContext returns a copy of e.client whose requests are bound to ctx.
+// mackerel.Client does not take contexts, so its transport attaches ctx to each request instead.
+func (e *Exporter) clientWithContext(ctx context.Context) *mackerel.Client {
+	hc := *e.client.HTTPClient
+	hc.Transport = &contextTransport{ctx: ctx, base: e.client.HTTPClient.Transport}
+	c := *e.client
+	c.HTTPClient = &hc
+	return &c
+}
+
+// contextTransport sends requests with ctx.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
 
-	return mackerel.NewClient(apiKey), nil
+// RoundTrip implements http.RoundTripper.
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
 }
@@ -1,6 +1,8 @@
 package mackerel
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -31,3 +33,37 @@ func TestExporterExport(t *testing.T) {
 		t.Errorf("Export: got %v", err)
 	}
 }
+
+func TestExporterExportWithContext(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("X-Tenant"); v != "t1" {
+			http.Error(w, "X-Tenant = "+v, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"success": true}`)) // nolint
+	}))
+	t.Cleanup(s.Close)
+
+	e, err := NewExporterWithConfig(&Config{
+		APIKey:  "xxx",
+		APIBase: s.URL,
+		Headers: map[string]string{"X-Tenant": "t1"},
+	})
+	if err != nil {
+		t.Fatal("NewExporterWithConfig: ", err)
+	}
+	reports := []*mackerel.CheckReport{{
+		Source: mackerel.NewCheckSourceHost("host1"),
+		Name:   "check",
+		Status: mackerel.CheckStatusOK,
+	}}
+	if err := e.PostCheckReportsWithContext(context.Background(), reports); err != nil {
+		t.Errorf("PostCheckReportsWithContext: got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.ExportWithContext(ctx, "Service1", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("ExportWithContext: got %v; want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,261 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// Name defines this exporter name.
+const Name = "otlp"
+
+// Protocols to send metrics.
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+const (
+	defaultHTTPEndpoint = "http://localhost:4318"
+	defaultGRPCEndpoint = "localhost:4317"
+	defaultTimeout      = 10 * time.Second
+
+	scopeName = "github.com/mackerelio-labs/mackerel-sql-metric-collector"
+
+	serviceAttribute   = "service.name"
+	queryNameAttribute = "query.name"
+)
+
+// Config represents the configuration of the exporter.
+type Config struct {
+	// Endpoint is the base URL such as "http://localhost:4318" for ProtocolHTTP,
+	// or the address such as "localhost:4317" for ProtocolGRPC.
+	Endpoint string
+
+	// Protocol is ProtocolHTTP or ProtocolGRPC. Empty means ProtocolHTTP.
+	Protocol string
+
+	// Headers are sent with each request.
+	Headers map[string]string
+
+	// Insecure disables TLS of gRPC connections.
+	Insecure bool
+
+	// CACert is PEM encoded certificates to verify the server. Empty means the system pool.
+	CACert string
+
+	// Timeout limits each export. Zero means 10 seconds.
+	Timeout time.Duration
+}
+
+// Exporter represents ...
+type Exporter struct {
+	config *Config
+	send   func(context.Context, *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsPartialSuccess, error)
+	close  func() error
+}
+
+// NewExporter is ...
+func NewExporter(conf *Config) (*Exporter, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(conf.CACert)) {
+			return nil, errors.New("otlp: no valid certificates in CA cert")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	e := &Exporter{config: conf}
+	switch conf.Protocol {
+	case "", ProtocolHTTP:
+		endpoint := conf.Endpoint
+		if endpoint == "" {
+			endpoint = defaultHTTPEndpoint
+		}
+		if !strings.HasSuffix(endpoint, "/v1/metrics") {
+			endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/metrics"
+		}
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+		e.send = func(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsPartialSuccess, error) {
+			return e.sendHTTP(ctx, client, endpoint, req)
+		}
+		e.close = func() error {
+			client.CloseIdleConnections()
+			return nil
+		}
+	case ProtocolGRPC:
+		endpoint := conf.Endpoint
+		if endpoint == "" {
+			endpoint = defaultGRPCEndpoint
+		}
+		creds := credentials.NewTLS(tlsConfig)
+		if conf.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		client := colmetricpb.NewMetricsServiceClient(conn)
+		e.send = func(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsPartialSuccess, error) {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.New(conf.Headers))
+			resp, err := client.Export(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return resp.GetPartialSuccess(), nil
+		}
+		e.close = conn.Close
+	default:
+		return nil, fmt.Errorf("otlp: unknown protocol %q", conf.Protocol)
+	}
+	return e, nil
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// ExportWithContext sends metrics as gauges. The service is set to the resource,
+// and the query name in ctx is set to the instrumentation scope.
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	req, err := newRequest(ctx, service, metrics)
+	if err != nil {
+		return err
+	}
+
+	timeout := e.config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ps, err := e.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	if n := ps.GetRejectedDataPoints(); n > 0 {
+		return fmt.Errorf("otlp: %d data points were rejected: %s", n, ps.GetErrorMessage())
+	}
+	return nil
+}
+
+// Close closes connections to the endpoint.
+func (e *Exporter) Close() error {
+	return e.close()
+}
+
+func newRequest(ctx context.Context, service string, metrics []*mackerel.MetricValue) (*colmetricpb.ExportMetricsServiceRequest, error) {
+	scope := &commonpb.InstrumentationScope{Name: scopeName}
+	if q, ok := query.FromContext(ctx); ok && q.GetName() != "" {
+		scope.Attributes = []*commonpb.KeyValue{stringAttribute(queryNameAttribute, q.GetName())}
+	}
+
+	ms := make([]*metricpb.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		p := &metricpb.NumberDataPoint{
+			TimeUnixNano: uint64(time.Unix(m.Time, 0).UnixNano()),
+		}
+		if n, ok := m.Value.(int64); ok {
+			p.Value = &metricpb.NumberDataPoint_AsInt{AsInt: n}
+		} else {
+			v, err := exporter.ToFloat64(m)
+			if err != nil {
+				return nil, err
+			}
+			p.Value = &metricpb.NumberDataPoint_AsDouble{AsDouble: v}
+		}
+		ms = append(ms, &metricpb.Metric{
+			Name: m.Name,
+			Data: &metricpb.Metric_Gauge{
+				Gauge: &metricpb.Gauge{DataPoints: []*metricpb.NumberDataPoint{p}},
+			},
+		})
+	}
+
+	return &colmetricpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricpb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{stringAttribute(serviceAttribute, service)},
+			},
+			ScopeMetrics: []*metricpb.ScopeMetrics{{
+				Scope:   scope,
+				Metrics: ms,
+			}},
+		}},
+	}, nil
+}
+
+func stringAttribute(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   k,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}},
+	}
+}
+
+func (e *Exporter) sendHTTP(ctx context.Context, client *http.Client, endpoint string, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsPartialSuccess, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.config.Headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &exporter.HTTPError{
+			Exporter:   "otlp",
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Body:       string(bytes.TrimSpace(data)),
+		}
+	}
+	var v colmetricpb.ExportMetricsServiceResponse
+	if err := proto.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("otlp: invalid response: %w", err)
+	}
+	return v.GetPartialSuccess(), nil
+}
@@ -0,0 +1,132 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/internal/exportertest"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+const wantRequest = `
+resource_metrics: {
+  resource: {attributes: {key: "service.name" value: {string_value: "Service1"}}}
+  scope_metrics: {
+    scope: {
+      name: "github.com/mackerelio-labs/mackerel-sql-metric-collector"
+      attributes: {key: "query.name" value: {string_value: "users"}}
+    }
+    metrics: {name: "users.count" gauge: {data_points: {time_unix_nano: 100000000000 as_int: 10}}}
+    metrics: {name: "users.ratio" gauge: {data_points: {time_unix_nano: 100000000000 as_double: 0.5}}}
+  }
+}`
+
+var testMetrics = []*mackerel.MetricValue{
+	{Name: "users.count", Value: int64(10), Time: 100},
+	{Name: "users.ratio", Value: 0.5, Time: 100},
+}
+
+func checkRequest(t *testing.T, got *colmetricpb.ExportMetricsServiceRequest) {
+	t.Helper()
+	var want colmetricpb.ExportMetricsServiceRequest
+	if err := prototext.Unmarshal([]byte(wantRequest), &want); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("request: (-want, +got)\n%s", diff)
+	}
+}
+
+func TestExporterHTTP(t *testing.T) {
+	var got colmetricpb.ExportMetricsServiceRequest
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Authorization") != "Bearer xxx" {
+			http.Error(w, r.URL.Path, http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, &got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	t.Cleanup(s.Close)
+
+	e, err := NewExporter(&Config{
+		Endpoint: s.URL,
+		Headers:  map[string]string{"Authorization": "Bearer xxx"},
+	})
+	if err != nil {
+		t.Fatal("NewExporter: ", err)
+	}
+	t.Cleanup(func() {
+		e.Close() // nolint
+	})
+	if err := e.ExportWithContext(exportertest.Context(&valuekey.Query{Name: "users"}), "Service1", testMetrics); err != nil {
+		t.Fatal("ExportWithContext: ", err)
+	}
+	checkRequest(t, &got)
+}
+
+type metricsServer struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	got    *colmetricpb.ExportMetricsServiceRequest
+	header metadata.MD
+}
+
+func (s *metricsServer) Export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	s.got = req
+	s.header, _ = metadata.FromIncomingContext(ctx)
+	return &colmetricpb.ExportMetricsServiceResponse{
+		PartialSuccess: &colmetricpb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: 1,
+			ErrorMessage:       "too old",
+		},
+	}, nil
+}
+
+func TestExporterGRPC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	ms := &metricsServer{}
+	colmetricpb.RegisterMetricsServiceServer(srv, ms)
+	go srv.Serve(ln) // nolint
+	t.Cleanup(srv.Stop)
+
+	e, err := NewExporter(&Config{
+		Endpoint: ln.Addr().String(),
+		Protocol: ProtocolGRPC,
+		Headers:  map[string]string{"x-api-key": "xxx"},
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal("NewExporter: ", err)
+	}
+	t.Cleanup(func() {
+		e.Close() // nolint
+	})
+	err = e.ExportWithContext(exportertest.Context(&valuekey.Query{Name: "users"}), "Service1", testMetrics)
+	if want := "otlp: 1 data points were rejected: too old"; err == nil || err.Error() != want {
+		t.Errorf("ExportWithContext: got %v; want %s", err, want)
+	}
+	checkRequest(t, ms.got)
+	if v := ms.header.Get("x-api-key"); len(v) != 1 || v[0] != "xxx" {
+		t.Errorf("x-api-key = %q; want xxx", v)
+	}
+}
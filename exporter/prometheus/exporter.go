@@ -0,0 +1,298 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Name defines this exporter name.
+const Name = "prometheus"
+
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+	serviceLabel = "service"
+)
+
+// Exporter keeps the latest metric values and exposes them as a Prometheus scrape endpoint.
+type Exporter struct {
+	mu sync.RWMutex
+	// samples holds the latest values exported by each query. They are replaced on every export,
+	// so series that the query no longer generates are removed.
+	samples  map[groupKey]map[sampleKey]float64
+	patterns []*labelPattern
+}
+
+// groupKey identifies metrics exported together.
+type groupKey struct {
+	service string
+	query   string
+}
+
+// sampleKey is the Prometheus metric name and formatted labels of a sample.
+type sampleKey struct {
+	name   string
+	labels string
+}
+
+// NewExporter returns an exporter. Each of templates is a metric name that can contain #{column},
+// and parts of metric names matching #{column} are exposed as labels named column.
+func NewExporter(templates []string) (*Exporter, error) {
+	e := &Exporter{
+		samples: make(map[groupKey]map[sampleKey]float64),
+	}
+	for _, t := range templates {
+		p, err := newLabelPattern(t)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			e.patterns = append(e.patterns, p)
+		}
+	}
+	return e, nil
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// ExportWithContext replaces the latest values of the query in ctx with metrics.
+// It returns an error if metrics are converted into the same series as other metrics.
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	g := groupKey{service: service}
+	if q, ok := query.FromContext(ctx); ok {
+		g.query = q.GetName()
+	}
+	samples := make(map[sampleKey]float64, len(metrics))
+	names := make(map[sampleKey]string, len(metrics))
+	for _, m := range metrics {
+		v, err := exporter.ToFloat64(m)
+		if err != nil {
+			return err
+		}
+		k := e.convert(service, m.Name)
+		if name, ok := names[k]; ok {
+			return fmt.Errorf("metrics %q and %q are exposed as the same series %s{%s}", name, m.Name, k.name, k.labels)
+		}
+		names[k] = m.Name
+		samples[k] = v
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for other, a := range e.samples {
+		if other == g {
+			continue
+		}
+		for k := range samples {
+			if _, ok := a[k]; ok {
+				return fmt.Errorf("metric %q is exposed as the same series %s{%s} as query %q", names[k], k.name, k.labels, other.query)
+			}
+		}
+	}
+	e.samples[g] = samples
+	return nil
+}
+
+// Serve serves /metrics on ln until ctx is done.
+func (e *Exporter) Serve(ctx context.Context, ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx) // nolint
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP writes the latest values in the Prometheus text format, or OpenMetrics if the client accepts it.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", contentTypeText)
+	}
+	e.write(w, openMetrics) // nolint
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+func (e *Exporter) write(w io.Writer, openMetrics bool) error {
+	families := make(map[string][]sample)
+	e.mu.RLock()
+	for _, a := range e.samples {
+		for k, v := range a {
+			families[k.name] = append(families[k.name], sample{labels: k.labels, value: v})
+		}
+	}
+	e.mu.RUnlock()
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		samples := families[name]
+		slices.SortFunc(samples, func(a, b sample) int {
+			return strings.Compare(a.labels, b.labels)
+		})
+		if _, err := fmt.Fprintf(w, "# TYPE %s gauge\n", name); err != nil {
+			return err
+		}
+		for _, s := range samples {
+			if _, err := fmt.Fprintf(w, "%s{%s} %s\n", name, s.labels, formatValue(s.value)); err != nil {
+				return err
+			}
+		}
+	}
+	if openMetrics {
+		if _, err := io.WriteString(w, "# EOF\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convert returns the Prometheus metric name and formatted labels of the metric named name.
+func (e *Exporter) convert(service, name string) sampleKey {
+	labels := [][2]string{{serviceLabel, service}}
+	s := name
+	for _, p := range e.patterns {
+		if n, l, ok := p.match(name); ok {
+			s = n
+			labels = append(labels, l...)
+			break
+		}
+	}
+
+	var b strings.Builder
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, l[0], labelValueReplacer.Replace(l[1])) // nolint
+	}
+	return sampleKey{name: metricName(s), labels: b.String()}
+}
+
+// labelValueReplacer escapes label values.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+var (
+	invalidMetricNameCharsRE = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	placeholderRE            = regexp.MustCompile(`#\{([a-z\_]+)\}`)
+)
+
+// metricName converts a dotted Mackerel metric name into a Prometheus metric name.
+func metricName(s string) string {
+	s = invalidMetricNameCharsRE.ReplaceAllString(s, "_")
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+// labelPattern matches metric names generated from a template that contains #{column}.
+type labelPattern struct {
+	re     *regexp.Regexp
+	name   string
+	labels []string
+}
+
+// newLabelPattern returns nil if template has no #{column}.
+// It returns an error if template has the same label more than once.
+func newLabelPattern(template string) (*labelPattern, error) {
+	if !placeholderRE.MatchString(template) {
+		return nil, nil
+	}
+	var (
+		expr   strings.Builder
+		labels []string
+		last   int
+	)
+	expr.WriteString(`\A`)
+	for _, m := range placeholderRE.FindAllStringSubmatchIndex(template, -1) {
+		expr.WriteString(regexp.QuoteMeta(template[last:m[0]]))
+		expr.WriteString(`([-a-zA-Z0-9_]+)`)
+		label := template[m[2]:m[3]]
+		if label == serviceLabel {
+			label = "exported_" + label
+		}
+		if slices.Contains(labels, label) {
+			return nil, fmt.Errorf("metric name %q has label %q more than once", template, label)
+		}
+		labels = append(labels, label)
+		last = m[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(template[last:]))
+	expr.WriteString(`\z`)
+
+	var segments []string
+	for _, s := range strings.Split(template, ".") {
+		if s = placeholderRE.ReplaceAllString(s, ""); s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return &labelPattern{
+		re:     regexp.MustCompile(expr.String()),
+		name:   strings.Join(segments, "."),
+		labels: labels,
+	}, nil
+}
+
+func (p *labelPattern) match(s string) (string, [][2]string, bool) {
+	m := p.re.FindStringSubmatch(s)
+	if m == nil {
+		return "", nil, false
+	}
+	labels := make([][2]string, len(p.labels))
+	for i, l := range p.labels {
+		labels[i] = [2]string{l, m[i+1]}
+	}
+	return p.name, labels, true
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,141 @@
+package prometheus
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/internal/exportertest"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestExporterServeHTTP(t *testing.T) {
+	e, err := NewExporter([]string{"tenants.users.#{tenant}", "jobs.#{queue}.#{service}.count", "total"})
+	if err != nil {
+		t.Fatal("NewExporter: ", err)
+	}
+	err = e.Export("Service1", []*mackerel.MetricValue{
+		{Name: "tenants.users.a", Value: int64(10), Time: 100},
+		{Name: "tenants.users.b", Value: 2.5, Time: 100},
+		{Name: "jobs.default.app.count", Value: int64(3), Time: 100},
+		{Name: "total", Value: int64(12), Time: 100},
+		{Name: "1st-place.x", Value: int64(1), Time: 100},
+	})
+	if err != nil {
+		t.Fatal("Export: ", err)
+	}
+	if err := e.Export("Service\"2", []*mackerel.MetricValue{{Name: "total", Value: int64(5), Time: 100}}); err != nil {
+		t.Fatal("Export: ", err)
+	}
+	if err := e.Export("Service1", []*mackerel.MetricValue{{Name: "invalid", Value: "x"}}); err == nil {
+		t.Error("Export: want an error")
+	}
+
+	testCases := map[string]struct {
+		accept      string
+		contentType string
+		eof         string
+	}{
+		"text": {
+			contentType: contentTypeText,
+		},
+		"openmetrics": {
+			accept:      "application/openmetrics-text;version=1.0.0,text/plain;q=0.5",
+			contentType: contentTypeOpenMetrics,
+			eof:         "# EOF\n",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			resp := w.Result()
+			if s := resp.Header.Get("Content-Type"); s != tc.contentType {
+				t.Errorf("Content-Type = %q; want %q", s, tc.contentType)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := `# TYPE _1st_place_x gauge
+_1st_place_x{service="Service1"} 1
+# TYPE jobs_count gauge
+jobs_count{service="Service1",queue="default",exported_service="app"} 3
+# TYPE tenants_users gauge
+tenants_users{service="Service1",tenant="a"} 10
+tenants_users{service="Service1",tenant="b"} 2.5
+# TYPE total gauge
+total{service="Service1"} 12
+total{service="Service\"2"} 5
+` + tc.eof
+			if diff := cmp.Diff(want, string(body)); diff != "" {
+				t.Errorf("ServeHTTP: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExporterReplace(t *testing.T) {
+	e, err := NewExporter([]string{"users.#{tenant}"})
+	if err != nil {
+		t.Fatal("NewExporter: ", err)
+	}
+	ctxA := exportertest.Context(&valuekey.Query{Name: "a"})
+	ctxB := exportertest.Context(&valuekey.Query{Name: "b"})
+	export := func(ctx context.Context, names ...string) error {
+		metrics := make([]*mackerel.MetricValue, len(names))
+		for i, name := range names {
+			metrics[i] = &mackerel.MetricValue{Name: name, Value: int64(i), Time: 100}
+		}
+		return e.ExportWithContext(ctx, "s", metrics)
+	}
+
+	if err := export(ctxA, "users.x", "users.y"); err != nil {
+		t.Fatal("Export: ", err)
+	}
+	if err := export(ctxB, "total"); err != nil {
+		t.Fatal("Export: ", err)
+	}
+	// users.x is no longer generated by the query a.
+	if err := export(ctxA, "users.y"); err != nil {
+		t.Fatal("Export: ", err)
+	}
+	if err := export(ctxB, "users.y"); err == nil {
+		t.Error("Export: want an error for the series of another query")
+	}
+	if err := export(ctxB, "a.b", "a_b"); err == nil {
+		t.Error("Export: want an error for metrics exposed as the same series")
+	}
+
+	var w strings.Builder
+	if err := e.write(&w, false); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE total gauge
+total{service="s"} 0
+# TYPE users gauge
+users{service="s",tenant="y"} 0
+`
+	if diff := cmp.Diff(want, w.String()); diff != "" {
+		t.Errorf("write: (-want, +got)\n%s", diff)
+	}
+}
+
+func TestNewExporterDuplicateLabels(t *testing.T) {
+	if _, err := NewExporter([]string{"jobs.#{queue}.#{queue}"}); err == nil {
+		t.Error("NewExporter: want an error")
+	}
+	if _, err := NewExporter([]string{"jobs.#{exported_service}.#{service}"}); err == nil {
+		t.Error("NewExporter: want an error")
+	}
+}
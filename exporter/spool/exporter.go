@@ -0,0 +1,247 @@
+// Package spool keeps metrics that failed to export, and exports them again later.
+package spool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Store stores spooled batches by keys.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+
+	// List returns all keys in lexical order.
+	List(ctx context.Context) ([]string, error)
+}
+
+// Open returns the store at u. The scheme must be "file" for a directory or "s3" for a prefix in a bucket.
+func Open(ctx context.Context, u *url.URL) (Store, error) {
+	switch u.Scheme {
+	case "", "file":
+		return NewDirStore(u.Path)
+	case "s3":
+		regionHint := u.Query().Get("regionHint")
+		if regionHint == "" {
+			regionHint = defaultRegionHint
+		}
+		return NewS3Store(ctx, u.Host, u.Path, regionHint)
+	default:
+		return nil, fmt.Errorf("spool: unsupported URL %q", u)
+	}
+}
+
+// batch is a spooled set of metrics that were exported at once.
+type batch struct {
+	Service   string                  `json:"service"`
+	Query     string                  `json:"query,omitempty"`
+	SpooledAt int64                   `json:"spooledAt"`
+	Metrics   []*mackerel.MetricValue `json:"metrics"`
+}
+
+var nowFunc = time.Now
+
+// Exporter wraps an exporter to spool batches that it failed to export.
+type Exporter struct {
+	exporter exporter.Exporter
+	store    Store
+	maxAge   time.Duration
+	logger   logr.Logger
+}
+
+// NewExporter returns an exporter that spools batches e failed to export into store.
+// Replay drops metrics older than maxAge. Zero maxAge means no limits.
+func NewExporter(e exporter.Exporter, store Store, maxAge time.Duration, logger logr.Logger) *Exporter {
+	return &Exporter{
+		exporter: e,
+		store:    store,
+		maxAge:   maxAge,
+		logger:   logger,
+	}
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// ExportWithContext exports metrics, or spools them if it failed with a retryable error.
+// It returns the error of the underlying exporter even if metrics are spooled.
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	err := e.exporter.ExportWithContext(ctx, service, metrics)
+	if err == nil || len(metrics) == 0 || !retryable(err) {
+		return err
+	}
+	now := nowFunc()
+	b := batch{
+		Service:   service,
+		SpooledAt: now.Unix(),
+		Metrics:   metrics,
+	}
+	if q, ok := query.FromContext(ctx); ok {
+		b.Query = q.GetName()
+	}
+	if serr := e.put(ctx, now, &b); serr != nil {
+		return errors.Join(err, fmt.Errorf("failed to spool metrics: %w", serr))
+	}
+	e.logger.Info("spooled metrics", "service", service, "query", b.Query, "count", len(metrics))
+	return err
+}
+
+// retryable reports whether exporting the same metrics later may succeed.
+func retryable(err error) bool {
+	var apiErr *mackerel.APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.StatusCode)
+	}
+	var httpErr *exporter.HTTPError
+	if errors.As(err, &httpErr) {
+		return retryableStatus(httpErr.StatusCode)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// Unwrap returns the underlying exporter.
+// Check reports are not spooled because stale results are misleading; they are posted to the underlying exporter directly.
+func (e *Exporter) Unwrap() exporter.Exporter {
+	return e.exporter
+}
+
+func (e *Exporter) put(ctx context.Context, t time.Time, b *batch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	// Keys are sorted by the time. The random suffix avoids conflicts between processes.
+	key := fmt.Sprintf("%s-%016x.json", t.UTC().Format("20060102T150405.000000000Z"), rand.Uint64())
+	return e.store.Put(ctx, key, data)
+}
+
+// rejectedDir is where Replay moves batches that the underlying exporter rejected.
+// Stores do not list keys under it.
+const rejectedDir = "rejected/"
+
+// Replay exports spooled batches in the order they are spooled.
+// Metrics older than maxAge are dropped. queries are used to restore the query of each batch.
+// It stops at the first retryable failure to keep the rest of batches.
+// Batches that failed with other errors are moved under rejected/ and the rest are replayed.
+func (e *Exporter) Replay(ctx context.Context, queries []query.Query) error {
+	keys, err := e.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]query.Query, len(queries))
+	for _, q := range queries {
+		byName[q.GetName()] = q
+	}
+
+	var errs []error
+	for _, key := range keys {
+		data, err := e.store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		b, err := decodeBatch(data)
+		if err != nil {
+			e.logger.Error(err, "drop broken spool", "key", key)
+			if err := e.store.Delete(ctx, key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		metrics := e.fresh(b.Metrics)
+		if n := len(b.Metrics) - len(metrics); n > 0 {
+			e.logger.Info("drop expired metrics", "key", key, "count", n)
+		}
+		if len(metrics) > 0 {
+			ctx := ctx
+			if q, ok := byName[b.Query]; ok && b.Query != "" {
+				ctx = query.NewContext(ctx, q)
+			}
+			if err := e.exporter.ExportWithContext(ctx, b.Service, metrics); err != nil {
+				err = fmt.Errorf("failed to replay %s: %w", key, err)
+				if retryable(err) {
+					return errors.Join(append(errs, err)...)
+				}
+				e.logger.Error(err, "reject spool", "key", key)
+				if err := e.store.Put(ctx, rejectedDir+key, data); err != nil {
+					return err
+				}
+				errs = append(errs, err)
+			} else {
+				e.logger.Info("replayed metrics", "service", b.Service, "query", b.Query, "count", len(metrics))
+			}
+		}
+		if err := e.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeBatch decodes data into a batch. Integer values are decoded as int64 as they are exported.
+func decodeBatch(data []byte) (*batch, error) {
+	var b batch
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&b); err != nil {
+		return nil, err
+	}
+	for _, m := range b.Metrics {
+		n, ok := m.Value.(json.Number)
+		if !ok {
+			continue
+		}
+		if v, err := n.Int64(); err == nil {
+			m.Value = v
+		} else if v, err := n.Float64(); err == nil {
+			m.Value = v
+		} else {
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+func (e *Exporter) fresh(metrics []*mackerel.MetricValue) []*mackerel.MetricValue {
+	if e.maxAge <= 0 {
+		return metrics
+	}
+	limit := nowFunc().Add(-e.maxAge).Unix()
+	a := make([]*mackerel.MetricValue, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Time >= limit {
+			a = append(a, m)
+		}
+	}
+	return a
+}
+
+// Close closes the underlying exporter if it implements io.Closer.
+func (e *Exporter) Close() error {
+	if c, ok := e.exporter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
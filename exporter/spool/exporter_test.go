@@ -0,0 +1,137 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/stdr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/internal/exportertest"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestExporterReplay(t *testing.T) {
+	now := time.Unix(10000, 0)
+	nowFunc = func() time.Time { return now }
+	t.Cleanup(func() { nowFunc = time.Now })
+
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errDown := &mackerel.APIError{StatusCode: 503, Message: "down"}
+	backend := &exportertest.Exporter{Err: errDown}
+	e := NewExporter(backend, store, time.Hour, logger)
+
+	q := &valuekey.Query{Name: "users"}
+	ctx := exportertest.Context(q)
+	err = e.ExportWithContext(ctx, "S1", []*mackerel.MetricValue{
+		{Name: "users.old", Value: int64(1), Time: 10000 - 7200},
+		{Name: "users.count", Value: int64(2), Time: 10000 - 60},
+	})
+	if !errors.Is(err, errDown) {
+		t.Errorf("ExportWithContext: got %v; want %v", err, errDown)
+	}
+	now = now.Add(time.Second)
+	err = e.ExportWithContext(ctx, "S1", []*mackerel.MetricValue{
+		{Name: "users.count", Value: int64(3), Time: 10000},
+	})
+	if !errors.Is(err, errDown) {
+		t.Errorf("ExportWithContext: got %v; want %v", err, errDown)
+	}
+
+	// Batches should be kept while the backend is down.
+	if err := e.Replay(context.Background(), []query.Query{q}); !errors.Is(err, errDown) {
+		t.Errorf("Replay: got %v; want %v", err, errDown)
+	}
+	if keys, _ := store.List(context.Background()); len(keys) != 2 {
+		t.Fatalf("List: got %v; want 2 keys", keys)
+	}
+
+	backend.Err = nil
+	if err := e.Replay(context.Background(), []query.Query{q}); err != nil {
+		t.Fatal("Replay:", err)
+	}
+	want := []*mackerel.MetricValue{
+		{Name: "users.count", Value: int64(2), Time: 10000 - 60},
+		{Name: "users.count", Value: int64(3), Time: 10000},
+	}
+	if diff := cmp.Diff(want, backend.Got); diff != "" {
+		t.Errorf("Replay: (-want, +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"users", "users"}, backend.Queries); diff != "" {
+		t.Errorf("Replay: queries (-want, +got)\n%s", diff)
+	}
+	if keys, _ := store.List(context.Background()); len(keys) != 0 {
+		t.Errorf("List: got %v; replayed batches should be deleted", keys)
+	}
+}
+
+func TestExporterNotRetryable(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errInvalid := &mackerel.APIError{StatusCode: 400, Message: "invalid"}
+	e := NewExporter(&exportertest.Exporter{Err: errInvalid}, store, 0, logger)
+
+	err = e.ExportWithContext(context.Background(), "S1", []*mackerel.MetricValue{
+		{Name: "users.count", Value: int64(1), Time: 10000},
+	})
+	if !errors.Is(err, errInvalid) {
+		t.Errorf("ExportWithContext: got %v; want %v", err, errInvalid)
+	}
+	if keys, _ := store.List(context.Background()); len(keys) != 0 {
+		t.Errorf("List: got %v; rejected metrics should not be spooled", keys)
+	}
+}
+
+func TestExporterReplayRejected(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &exportertest.Exporter{Err: &mackerel.APIError{StatusCode: 502, Message: "bad gateway"}}
+	e := NewExporter(backend, store, 0, logger)
+	for _, service := range []string{"Removed", "S1"} {
+		err := e.ExportWithContext(context.Background(), service, []*mackerel.MetricValue{
+			{Name: "users.count", Value: 1.5, Time: 10000},
+		})
+		if err == nil {
+			t.Fatal("ExportWithContext: got nil; want an error")
+		}
+	}
+
+	errNotFound := &mackerel.APIError{StatusCode: 404, Message: "service not found"}
+	backend.Err = nil
+	backend.Errs = map[string]error{"Removed": errNotFound}
+	if err := e.Replay(context.Background(), nil); !errors.Is(err, errNotFound) {
+		t.Errorf("Replay: got %v; want %v", err, errNotFound)
+	}
+	want := []*mackerel.MetricValue{
+		{Name: "users.count", Value: 1.5, Time: 10000},
+	}
+	if diff := cmp.Diff(want, backend.Got); diff != "" {
+		t.Errorf("Replay: (-want, +got)\n%s", diff)
+	}
+	if keys, _ := store.List(context.Background()); len(keys) != 0 {
+		t.Errorf("List: got %v; rejected batches should be moved aside", keys)
+	}
+	rejected, err := NewDirStore(filepath.Join(store.dir, "rejected"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := rejected.List(context.Background()); len(keys) != 1 {
+		t.Errorf("List: got %v; want 1 rejected batch", keys)
+	}
+}
@@ -0,0 +1,161 @@
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Name defines this exporter name.
+const Name = "statsd"
+
+// Formats of lines.
+const (
+	FormatStatsD    = "statsd"
+	FormatDogStatsD = "dogstatsd"
+)
+
+const (
+	defaultAddress = "localhost:8125"
+	unixPrefix     = "unix://"
+
+	// Default sizes of packets that fit into the common MTU of each network.
+	defaultUDPPacketSize  = 1432
+	defaultUnixPacketSize = 8192
+)
+
+// Config represents the configuration of the exporter.
+type Config struct {
+	// Address is "host:port" of UDP, or "unix:///path/to/socket" of a Unix datagram socket.
+	Address string
+
+	// Format is FormatStatsD or FormatDogStatsD. Empty means FormatStatsD.
+	Format string
+
+	// Prefix is prepended to each metric name.
+	Prefix string
+
+	// Tags adds the service and the query name as tags. It is supported only by FormatDogStatsD.
+	Tags bool
+
+	// MaxPacketSize limits the size of each packet. Zero means the default size for the network.
+	MaxPacketSize int
+}
+
+// Exporter sends metrics as gauges.
+type Exporter struct {
+	config *Config
+	conn   net.Conn
+	size   int
+}
+
+// NewExporter is ...
+func NewExporter(conf *Config) (*Exporter, error) {
+	switch conf.Format {
+	case "", FormatStatsD:
+		if conf.Tags {
+			return nil, fmt.Errorf("statsd: tags are not supported by %s format", FormatStatsD)
+		}
+	case FormatDogStatsD:
+	default:
+		return nil, fmt.Errorf("statsd: unknown format %q", conf.Format)
+	}
+
+	network, addr, size := "udp", conf.Address, defaultUDPPacketSize
+	if addr == "" {
+		addr = defaultAddress
+	}
+	if s, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		network, addr, size = "unixgram", s, defaultUnixPacketSize
+	}
+	if conf.MaxPacketSize > 0 {
+		size = conf.MaxPacketSize
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{
+		config: conf,
+		conn:   conn,
+		size:   size,
+	}, nil
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// ExportWithContext sends metrics in packets as large as possible up to MaxPacketSize.
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	var tags string
+	if e.config.Tags {
+		tags = "|#" + tag("service", service)
+		if q, ok := query.FromContext(ctx); ok && q.GetName() != "" {
+			tags += "," + tag("query", q.GetName())
+		}
+	}
+
+	var (
+		buf  bytes.Buffer
+		errs []error
+	)
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(buf.Bytes()); err != nil {
+			errs = append(errs, err)
+		}
+		buf.Reset()
+	}
+	for _, m := range metrics {
+		v, err := exporter.ToFloat64(m)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, line := range e.lines(m.Name, v, tags) {
+			if buf.Len() > 0 && buf.Len()+1+len(line) > e.size {
+				flush()
+			}
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(line)
+		}
+	}
+	flush()
+	return errors.Join(errs...)
+}
+
+// lines returns lines that set the gauge name to v.
+func (e *Exporter) lines(name string, v float64, tags string) []string {
+	name = e.config.Prefix + name
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if v < 0 && e.config.Format != FormatDogStatsD {
+		// StatsD treats signed values as deltas, so it needs to reset the gauge to zero first.
+		return []string{name + ":0|g", name + ":" + s + "|g"}
+	}
+	return []string{name + ":" + s + "|g" + tags}
+}
+
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+func tag(k, v string) string {
+	return k + ":" + tagReplacer.Replace(v)
+}
+
+// Close closes the connection.
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
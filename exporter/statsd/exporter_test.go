@@ -0,0 +1,85 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/internal/exportertest"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestExporterExport(t *testing.T) {
+	metrics := []*mackerel.MetricValue{
+		{Name: "users.count", Value: int64(10), Time: 100},
+		{Name: "users.ratio", Value: 0.5, Time: 100},
+		{Name: "users.delta", Value: int64(-3), Time: 100},
+	}
+	testCases := map[string]struct {
+		conf *Config
+		want []string
+	}{
+		"statsd": {
+			conf: &Config{Prefix: "sql."},
+			want: []string{
+				"sql.users.count:10|g\nsql.users.ratio:0.5|g\nsql.users.delta:0|g\nsql.users.delta:-3|g",
+			},
+		},
+		"dogstatsd": {
+			conf: &Config{Format: FormatDogStatsD, Tags: true, MaxPacketSize: 100},
+			want: []string{
+				"users.count:10|g|#service:Service_1,query:users\nusers.ratio:0.5|g|#service:Service_1,query:users",
+				"users.delta:-3|g|#service:Service_1,query:users",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				pc.Close() // nolint
+			})
+			tc.conf.Address = pc.LocalAddr().String()
+			e, err := NewExporter(tc.conf)
+			if err != nil {
+				t.Fatal("NewExporter: ", err)
+			}
+			t.Cleanup(func() {
+				e.Close() // nolint
+			})
+
+			ctx := exportertest.Context(&valuekey.Query{Name: "users"})
+			if err := e.ExportWithContext(ctx, "Service,1", metrics); err != nil {
+				t.Fatal("ExportWithContext: ", err)
+			}
+
+			var got []string
+			buf := make([]byte, 65536)
+			for range tc.want {
+				pc.SetReadDeadline(time.Now().Add(5 * time.Second)) // nolint
+				n, _, err := pc.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(buf[:n]))
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("packets: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewExporter_error(t *testing.T) {
+	if _, err := NewExporter(&Config{Tags: true}); err == nil {
+		t.Error("NewExporter: tags with statsd format should be an error")
+	}
+	if _, err := NewExporter(&Config{Format: "graphite"}); err == nil {
+		t.Error("NewExporter: unknown format should be an error")
+	}
+}
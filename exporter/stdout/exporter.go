@@ -1,24 +1,75 @@
 package stdout
 
 import (
+	"cmp"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
 	"github.com/mackerelio/mackerel-client-go"
 )
 
 // Name defines this exporter name.
 const Name = "stdout"
 
+// Output formats.
+const (
+	FormatTSV    = "tsv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+	FormatTable  = "table"
+)
+
+// Formats is the list of supported formats.
+var Formats = []string{FormatTSV, FormatJSON, FormatNDJSON, FormatCSV, FormatTable}
+
 // Exporter represents ...
-type Exporter struct{}
+type Exporter struct {
+	w      io.Writer
+	format string
+
+	mu      sync.Mutex
+	records []*record        // buffered records for FormatJSON and FormatTable
+	plans   []*exporter.Plan // buffered plans for FormatJSON and FormatTable
+	csv     *csv.Writer
+}
+
+type record struct {
+	Service string  `json:"service"`
+	Query   string  `json:"query"`
+	Name    string  `json:"name"`
+	Value   float64 `json:"value"`
+	Time    int64   `json:"time"`
+}
 
 // NewExporter is ...
 func NewExporter() *Exporter {
-	return &Exporter{}
+	return &Exporter{w: os.Stdout, format: FormatTSV}
+}
+
+// NewExporterWithFormat returns an exporter that writes metrics into w in format.
+// FormatJSON and FormatTable write all metrics at once when the exporter is flushed or closed.
+func NewExporterWithFormat(w io.Writer, format string) (*Exporter, error) {
+	if format == "" {
+		format = FormatTSV
+	}
+	if !slices.Contains(Formats, format) {
+		return nil, fmt.Errorf("stdout: unknown format %q", format)
+	}
+	return &Exporter{w: w, format: format}, nil
 }
 
 // Export is ...
@@ -27,42 +78,196 @@ func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error
 }
 
 // ExportWithContext is ...
-func (e *Exporter) ExportWithContext(_ context.Context, _ string, metrics []*mackerel.MetricValue) error {
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	var queryName string
+	if q, ok := query.FromContext(ctx); ok {
+		queryName = q.GetName()
+	}
+
+	records := make([]*record, 0, len(metrics))
 	for _, m := range metrics {
-		err := printValue(os.Stdout, m)
+		v, err := metricValue(m)
 		if err != nil {
 			return err
 		}
+		records = append(records, &record{
+			Service: service,
+			Query:   queryName,
+			Name:    m.Name,
+			Value:   v,
+			Time:    m.Time,
+		})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch e.format {
+	case FormatJSON, FormatTable:
+		e.records = append(e.records, records...)
+		return nil
+	case FormatNDJSON:
+		enc := json.NewEncoder(e.w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		if e.csv == nil {
+			e.csv = csv.NewWriter(e.w)
+			if err := e.csv.Write([]string{"service", "query", "name", "value", "time"}); err != nil {
+				return err
+			}
+		}
+		for _, r := range records {
+			err := e.csv.Write([]string{r.Service, r.Query, r.Name, formatFloat(r.Value), strconv.FormatInt(r.Time, 10)})
+			if err != nil {
+				return err
+			}
+		}
+		e.csv.Flush()
+		return e.csv.Error()
+	default:
+		for _, r := range records {
+			if _, err := fmt.Fprintf(e.w, "%s\t%f\t%d\n", r.Name, r.Value, r.Time); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	return nil
 }
 
-func printValue(w io.Writer, metric *mackerel.MetricValue) error {
-	var v float64
-
-	switch i := metric.Value.(type) {
-	case int32:
-		v = float64(i)
-	case uint32:
-		v = float64(i)
-	case float32:
-		v = float64(i)
-	case int64: // rounded
-		v = float64(i)
-	case uint64: // rounded
-		v = float64(i)
-	case float64:
-		v = i
+// WritePlan writes the result of a dry run in the format of e.
+// FormatJSON and FormatTable write all plans at once when the exporter is flushed or closed.
+// FormatTable omits the plans themselves.
+func (e *Exporter) WritePlan(p *exporter.Plan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch e.format {
+	case FormatJSON, FormatTable:
+		e.plans = append(e.plans, p)
+		return nil
+	case FormatNDJSON:
+		return json.NewEncoder(e.w).Encode(p)
+	case FormatCSV:
+		if e.csv == nil {
+			e.csv = csv.NewWriter(e.w)
+			if err := e.csv.Write([]string{"query", "service", "exporters", "check", "metrics", "plan", "error"}); err != nil {
+				return err
+			}
+		}
+		err := e.csv.Write([]string{
+			p.Query,
+			p.Service,
+			strings.Join(p.Exporters, ","),
+			p.Check,
+			strings.Join(p.Metrics, ","),
+			strings.Join(p.Plan, "\n"),
+			p.Error,
+		})
+		if err != nil {
+			return err
+		}
+		e.csv.Flush()
+		return e.csv.Error()
 	default:
-		v = math.NaN()
+		var b strings.Builder
+		fmt.Fprintf(&b, "query: %s\n", p.Query)
+		fmt.Fprintf(&b, "service: %s\n", p.Service)
+		if len(p.Exporters) > 0 {
+			fmt.Fprintf(&b, "exporters: %s\n", strings.Join(p.Exporters, ", "))
+		}
+		if p.Check != "" {
+			fmt.Fprintf(&b, "check: %s\n", p.Check)
+		} else if p.Metrics != nil {
+			b.WriteString("metrics:\n")
+			for _, name := range p.Metrics {
+				fmt.Fprintf(&b, "\t%s\n", name)
+			}
+		}
+		if p.Plan != nil || p.Error == "" {
+			b.WriteString("plan:\n")
+			for _, l := range p.Plan {
+				fmt.Fprintf(&b, "\t%s\n", l)
+			}
+		}
+		if p.Error != "" {
+			fmt.Fprintf(&b, "error: %s\n", p.Error)
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(e.w, b.String())
+		return err
+	}
+}
 
+// Close writes buffered metrics and plans.
+func (e *Exporter) Close() error {
+	return e.Flush()
+}
+
+// Flush writes buffered metrics sorted by the service and the query name, and buffered plans.
+// Nothing is written if nothing is buffered. The collector calls it at the end of each run.
+func (e *Exporter) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	records, plans := e.records, e.plans
+	e.records, e.plans = nil, nil
+	if len(plans) > 0 {
+		if err := e.writePlans(plans); err != nil {
+			return err
+		}
+	}
+	if len(records) == 0 {
+		return nil
 	}
+	slices.SortStableFunc(records, func(a, b *record) int {
+		return cmp.Or(cmp.Compare(a.Service, b.Service), cmp.Compare(a.Query, b.Query))
+	})
 
-	if math.IsNaN(v) || math.IsInf(v, 0) {
-		return fmt.Errorf("invalid metric.Value: key = %s, metric.Value = (%T)%v", metric.Name, metric.Value, metric.Value)
+	switch e.format {
+	case FormatJSON:
+		enc := json.NewEncoder(e.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatTable:
+		tw := tabwriter.NewWriter(e.w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "SERVICE\tQUERY\tNAME\tVALUE\tTIME") // nolint
+		for _, r := range records {
+			t := time.Unix(r.Time, 0).Format(time.RFC3339)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Service, r.Query, r.Name, formatFloat(r.Value), t) // nolint
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+// writePlans writes plans buffered for FormatJSON and FormatTable.
+func (e *Exporter) writePlans(plans []*exporter.Plan) error {
+	if e.format == FormatJSON {
+		enc := json.NewEncoder(e.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plans)
+	}
+	tw := tabwriter.NewWriter(e.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUERY\tSERVICE\tEXPORTERS\tCHECK\tMETRICS\tERROR") // nolint
+	for _, p := range plans {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Query, p.Service, strings.Join(p.Exporters, ","), p.Check, strings.Join(p.Metrics, ","), p.Error) // nolint
 	}
+	return tw.Flush()
+}
 
-	fmt.Fprintf(w, "%s\t%f\t%d\n", metric.Name, v, metric.Time) // nolint
+func metricValue(m *mackerel.MetricValue) (float64, error) {
+	v, err := exporter.ToFloat64(m)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid metric.Value: key = %s, metric.Value = (%T)%v", m.Name, m.Value, m.Value)
+	}
+	return v, nil
+}
 
-	return nil
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
 }
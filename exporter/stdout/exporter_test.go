@@ -0,0 +1,232 @@
+package stdout
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/exporter"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/internal/exportertest"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestExporterFormats(t *testing.T) {
+	t1 := time.Unix(100, 0).Format(time.RFC3339)
+	testCases := map[string]string{
+		FormatTSV: `users.count	10.000000	100
+users.ratio	0.500000	100
+jobs.count	3.000000	100
+`,
+		FormatNDJSON: `{"service":"S2","query":"users","name":"users.count","value":10,"time":100}
+{"service":"S2","query":"users","name":"users.ratio","value":0.5,"time":100}
+{"service":"S1","query":"jobs","name":"jobs.count","value":3,"time":100}
+`,
+		FormatCSV: `service,query,name,value,time
+S2,users,users.count,10,100
+S2,users,users.ratio,0.5,100
+S1,jobs,jobs.count,3,100
+`,
+		FormatJSON: `[
+  {
+    "service": "S1",
+    "query": "jobs",
+    "name": "jobs.count",
+    "value": 3,
+    "time": 100
+  },
+  {
+    "service": "S2",
+    "query": "users",
+    "name": "users.count",
+    "value": 10,
+    "time": 100
+  },
+  {
+    "service": "S2",
+    "query": "users",
+    "name": "users.ratio",
+    "value": 0.5,
+    "time": 100
+  }
+]
+`,
+		FormatTable: `SERVICE  QUERY  NAME         VALUE  TIME
+S1       jobs   jobs.count   3      ` + t1 + `
+S2       users  users.count  10     ` + t1 + `
+S2       users  users.ratio  0.5    ` + t1 + `
+`,
+	}
+	for format, want := range testCases {
+		t.Run(format, func(t *testing.T) {
+			var w strings.Builder
+			e, err := NewExporterWithFormat(&w, format)
+			if err != nil {
+				t.Fatal("NewExporterWithFormat: ", err)
+			}
+			ctx := exportertest.Context(&valuekey.Query{Name: "users"})
+			err = e.ExportWithContext(ctx, "S2", []*mackerel.MetricValue{
+				{Name: "users.count", Value: int64(10), Time: 100},
+				{Name: "users.ratio", Value: 0.5, Time: 100},
+			})
+			if err != nil {
+				t.Fatal("ExportWithContext: ", err)
+			}
+			ctx = exportertest.Context(&valuekey.Query{Name: "jobs"})
+			err = e.ExportWithContext(ctx, "S1", []*mackerel.MetricValue{
+				{Name: "jobs.count", Value: int64(3), Time: 100},
+			})
+			if err != nil {
+				t.Fatal("ExportWithContext: ", err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal("Close: ", err)
+			}
+			if diff := cmp.Diff(want, w.String()); diff != "" {
+				t.Errorf("output: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewExporterWithFormat_error(t *testing.T) {
+	if _, err := NewExporterWithFormat(nil, "xml"); err == nil {
+		t.Error("NewExporterWithFormat: unknown format should be an error")
+	}
+}
+
+func TestExporterFlush(t *testing.T) {
+	var w strings.Builder
+	e, err := NewExporterWithFormat(&w, FormatJSON)
+	if err != nil {
+		t.Fatal("NewExporterWithFormat: ", err)
+	}
+	ctx := exportertest.Context(&valuekey.Query{Name: "users"})
+	for _, v := range []int64{1, 2} {
+		err := e.ExportWithContext(ctx, "S1", []*mackerel.MetricValue{{Name: "users.count", Value: v, Time: 100}})
+		if err != nil {
+			t.Fatal("ExportWithContext: ", err)
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatal("Flush: ", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+	want := `[
+  {
+    "service": "S1",
+    "query": "users",
+    "name": "users.count",
+    "value": 1,
+    "time": 100
+  }
+]
+[
+  {
+    "service": "S1",
+    "query": "users",
+    "name": "users.count",
+    "value": 2,
+    "time": 100
+  }
+]
+`
+	if diff := cmp.Diff(want, w.String()); diff != "" {
+		t.Errorf("output: (-want, +got)\n%s", diff)
+	}
+}
+
+func TestExporterWritePlan(t *testing.T) {
+	plans := []*exporter.Plan{
+		{Query: "users", Service: "S1", Exporters: []string{"mackerel"}, Metrics: []string{"users.count"}, Plan: []string{"SCAN users"}},
+		{Query: "lag", Service: "S1", Check: "replica-lag", Error: "syntax error"},
+	}
+	testCases := map[string]string{
+		FormatTSV: `query: users
+service: S1
+exporters: mackerel
+metrics:
+	users.count
+plan:
+	SCAN users
+
+query: lag
+service: S1
+check: replica-lag
+error: syntax error
+
+`,
+		FormatNDJSON: `{"query":"users","service":"S1","exporters":["mackerel"],"metrics":["users.count"],"plan":["SCAN users"]}
+{"query":"lag","service":"S1","check":"replica-lag","error":"syntax error"}
+`,
+		FormatCSV: `query,service,exporters,check,metrics,plan,error
+users,S1,mackerel,,users.count,SCAN users,
+lag,S1,,replica-lag,,,syntax error
+`,
+		FormatJSON: `[
+  {
+    "query": "users",
+    "service": "S1",
+    "exporters": [
+      "mackerel"
+    ],
+    "metrics": [
+      "users.count"
+    ],
+    "plan": [
+      "SCAN users"
+    ]
+  },
+  {
+    "query": "lag",
+    "service": "S1",
+    "check": "replica-lag",
+    "error": "syntax error"
+  }
+]
+`,
+		FormatTable: `QUERY  SERVICE  EXPORTERS  CHECK        METRICS      ERROR
+users  S1       mackerel                users.count  
+lag    S1                  replica-lag               syntax error
+`,
+	}
+	for format, want := range testCases {
+		t.Run(format, func(t *testing.T) {
+			var w strings.Builder
+			e, err := NewExporterWithFormat(&w, format)
+			if err != nil {
+				t.Fatal("NewExporterWithFormat: ", err)
+			}
+			for _, p := range plans {
+				if err := e.WritePlan(p); err != nil {
+					t.Fatal("WritePlan: ", err)
+				}
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal("Close: ", err)
+			}
+			if diff := cmp.Diff(want, w.String()); diff != "" {
+				t.Errorf("output: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExporterCloseEmpty(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatTable} {
+		var w strings.Builder
+		e, err := NewExporterWithFormat(&w, format)
+		if err != nil {
+			t.Fatal("NewExporterWithFormat: ", err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal("Close: ", err)
+		}
+		if w.Len() > 0 {
+			t.Errorf("Close(%s) wrote %q; want nothing", format, w.String())
+		}
+	}
+}
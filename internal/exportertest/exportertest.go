@@ -0,0 +1,53 @@
+// Package exportertest provides helpers for testing exporters.
+package exportertest
+
+import (
+	"context"
+
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query/valuekey"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Context returns a context that carries q.
+func Context(q *valuekey.Query) context.Context {
+	return query.NewContext(context.Background(), q)
+}
+
+// Exporter is a fake exporter that records exported metrics.
+type Exporter struct {
+	Err  error            // returned by every export
+	Errs map[string]error // returned by exports to each service
+
+	Calls   int                     // number of exports
+	Got     []*mackerel.MetricValue // metrics exported successfully
+	Queries []string                // names of queries of successful exports
+	Closed  bool
+}
+
+// Export is ...
+func (e *Exporter) Export(service string, metrics []*mackerel.MetricValue) error {
+	return e.ExportWithContext(context.Background(), service, metrics)
+}
+
+// ExportWithContext records metrics unless it returns Err or Errs[service].
+func (e *Exporter) ExportWithContext(ctx context.Context, service string, metrics []*mackerel.MetricValue) error {
+	e.Calls++
+	if e.Err != nil {
+		return e.Err
+	}
+	if err := e.Errs[service]; err != nil {
+		return err
+	}
+	if q, ok := query.FromContext(ctx); ok {
+		e.Queries = append(e.Queries, q.GetName())
+	}
+	e.Got = append(e.Got, metrics...)
+	return nil
+}
+
+// Close is ...
+func (e *Exporter) Close() error {
+	e.Closed = true
+	return nil
+}
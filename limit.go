@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Policies for series over Config.MaxSeries.
+const (
+	SeriesOverflowDrop  = "drop"
+	SeriesOverflowError = "error"
+)
+
+// seriesLimiter limits the number of series across all queries in a run.
+// Queries run concurrently, so series are admitted in the order of queries to get the same result in every run.
+type seriesLimiter struct {
+	max    int
+	policy string
+
+	mu     sync.Mutex
+	series map[string]struct{}
+	// passed[i] is true if the i-th query has passed the limiter, and next is the first query that has not.
+	passed []bool
+	next   int
+	turn   *sync.Cond
+}
+
+func newSeriesLimiter(max int, policy string, n int) *seriesLimiter {
+	l := &seriesLimiter{
+		max:    max,
+		policy: policy,
+		series: make(map[string]struct{}),
+		passed: make([]bool, n),
+	}
+	l.turn = sync.NewCond(&l.mu)
+	return l
+}
+
+// done marks the i-th query as passed. It must be called for every query even if the query failed.
+func (l *seriesLimiter) done(i int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.passed[i] = true
+	for l.next < len(l.passed) && l.passed[l.next] {
+		l.next++
+	}
+	l.turn.Broadcast()
+}
+
+// limit returns metrics of the i-th query within the limit.
+// It waits until all preceding queries have passed the limiter.
+// Series are identified by the service and the metric name, and metrics about the collector itself are not counted.
+func (l *seriesLimiter) limit(i int, q query.Query, service string, metrics []*mackerel.MetricValue, logger logr.Logger) ([]*mackerel.MetricValue, error) {
+	defer l.done(i)
+	if l.max <= 0 {
+		return metrics, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.next < i {
+		l.turn.Wait()
+	}
+
+	a := metrics[:0:0]
+	overflow := make(map[string]struct{})
+	for _, m := range metrics {
+		if strings.HasPrefix(m.Name, query.SelfMetricPrefix+".") {
+			a = append(a, m)
+			continue
+		}
+		key := service + "\x00" + m.Name
+		if _, ok := l.series[key]; !ok {
+			if len(l.series) >= l.max {
+				overflow[m.Name] = struct{}{}
+				continue
+			}
+			l.series[key] = struct{}{}
+		}
+		a = append(a, m)
+	}
+	if len(overflow) == 0 {
+		return a, nil
+	}
+	if l.policy == SeriesOverflowError {
+		return nil, fmt.Errorf("query %s: metrics exceeded %d series in total", q.GetName(), l.max)
+	}
+	logger.Info("metrics exceeded the maximum number of series in total", "query", q.GetName(), "maxSeries", l.max, "overflow", len(overflow))
+	name := query.OverflowSeriesMetricName(q.GetName())
+	for _, m := range a {
+		if m.Name == name {
+			if n, ok := m.Value.(int64); ok {
+				m.Value = n + int64(len(overflow))
+				return a, nil
+			}
+		}
+	}
+	var t int64
+	if len(metrics) > 0 {
+		t = metrics[0].Time
+	}
+	a = append(a, &mackerel.MetricValue{
+		Name:  name,
+		Value: int64(len(overflow)),
+		Time:  t,
+	})
+	return a, nil
+}
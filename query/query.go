@@ -3,6 +3,7 @@ package query
 import (
 	"context"
 	"database/sql"
+	"regexp"
 
 	"github.com/go-logr/logr"
 	"github.com/mackerelio/mackerel-client-go"
@@ -12,5 +13,78 @@ import (
 type Query interface {
 	Execute(*sql.DB, logr.Logger) ([]*mackerel.MetricValue, error)
 	ExecuteWithContext(context.Context, *sql.DB, logr.Logger) ([]*mackerel.MetricValue, error)
+	GetName() string
 	GetService() string
 }
+
+// Statement is implemented by queries that can be inspected without executing them.
+type Statement interface {
+	Query
+
+	// StatementWithContext returns the SQL and its evaluated parameters.
+	StatementWithContext(context.Context, logr.Logger) (string, []any, error)
+
+	// MetricNames returns the names of metrics that the query may post.
+	// Names can contain #{column} that is replaced with the column value.
+	MetricNames() []string
+}
+
+// Checker is implemented by queries that can report check monitoring results instead of metrics.
+type Checker interface {
+	Query
+
+	// IsCheck reports whether the query reports a check monitoring result.
+	IsCheck() bool
+
+	// CheckName returns the name of the check monitoring.
+	CheckName() string
+
+	// CheckWithContext runs the query and returns the check report.
+	// Source of the report is nil if the query does not specify the host.
+	// If it failed, it returns the error and the report whose status is UNKNOWN.
+	CheckWithContext(context.Context, *sql.DB, logr.Logger) (*mackerel.CheckReport, error)
+}
+
+// Router is implemented by queries that choose exporters their metrics are sent to.
+type Router interface {
+	// GetExporters returns names of the exporters. Empty means all exporters.
+	GetExporters() []string
+}
+
+// SelfMetricPrefix is the prefix of metrics about the collector itself.
+const SelfMetricPrefix = "sql_metric_collector"
+
+var invalidMetricKeyCharsRE = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// OverflowSeriesMetricName returns the name of the metric that counts series over the limit in the query.
+func OverflowSeriesMetricName(queryName string) string {
+	if queryName == "" {
+		queryName = "unnamed"
+	}
+	return SelfMetricPrefix + ".overflow_series." + invalidMetricKeyCharsRE.ReplaceAllString(queryName, "_")
+}
+
+// maxCheckMessageLength is the maximum number of characters in a message of check reports.
+const maxCheckMessageLength = 1024
+
+// TruncateCheckMessage returns s truncated to fit in a message of check reports.
+func TruncateCheckMessage(s string) string {
+	if r := []rune(s); len(r) > maxCheckMessageLength {
+		return string(r[:maxCheckMessageLength-3]) + "..."
+	}
+	return s
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries q.
+// Exporters can use it to know which query generated metrics.
+func NewContext(ctx context.Context, q Query) context.Context {
+	return context.WithValue(ctx, contextKey{}, q)
+}
+
+// FromContext returns the query stored in ctx, if any.
+func FromContext(ctx context.Context) (Query, bool) {
+	q, ok := ctx.Value(contextKey{}).(Query)
+	return q, ok
+}
@@ -0,0 +1,189 @@
+package valuekey
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Operators that compare values with thresholds.
+const (
+	CheckOperatorGreater      = ">"
+	CheckOperatorGreaterEqual = ">="
+	CheckOperatorLess         = "<"
+	CheckOperatorLessEqual    = "<="
+)
+
+// Check evaluates each row against thresholds, then reports the worst status as a check monitoring result.
+type Check struct {
+	// Name is the name of the check monitoring. Empty means the query name.
+	Name string `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty"`
+
+	// Host is the ID of the host that the report belongs to. Empty means the host given to the collector.
+	Host string `yaml:"host,omitempty" json:"host,omitempty" toml:"host,omitempty"`
+
+	// Value is the column compared with thresholds.
+	Value string `yaml:"value" json:"value" toml:"value"`
+
+	// Operator is how values are compared with thresholds. Empty means ">".
+	Operator string `yaml:"operator,omitempty" json:"operator,omitempty" toml:"operator,omitempty"`
+
+	Warning  *float64 `yaml:"warning,omitempty" json:"warning,omitempty" toml:"warning,omitempty"`
+	Critical *float64 `yaml:"critical,omitempty" json:"critical,omitempty" toml:"critical,omitempty"`
+
+	// Message is the message of each row. #{column} is replaced with the value of the column.
+	Message string `yaml:"message,omitempty" json:"message,omitempty" toml:"message,omitempty"`
+
+	NotificationInterval uint `yaml:"notificationInterval,omitempty" json:"notificationInterval,omitempty" toml:"notificationInterval,omitempty"`
+	MaxCheckAttempts     uint `yaml:"maxCheckAttempts,omitempty" json:"maxCheckAttempts,omitempty" toml:"maxCheckAttempts,omitempty"`
+}
+
+func (c *Check) exceeds(v, threshold float64) bool {
+	switch c.Operator {
+	case CheckOperatorGreaterEqual:
+		return v >= threshold
+	case CheckOperatorLess:
+		return v < threshold
+	case CheckOperatorLessEqual:
+		return v <= threshold
+	default:
+		return v > threshold
+	}
+}
+
+func (c *Check) status(v float64) mackerel.CheckStatus {
+	switch {
+	case c.Critical != nil && c.exceeds(v, *c.Critical):
+		return mackerel.CheckStatusCritical
+	case c.Warning != nil && c.exceeds(v, *c.Warning):
+		return mackerel.CheckStatusWarning
+	default:
+		return mackerel.CheckStatusOK
+	}
+}
+
+var checkStatusLevels = map[mackerel.CheckStatus]int{
+	mackerel.CheckStatusOK:       0,
+	mackerel.CheckStatusWarning:  1,
+	mackerel.CheckStatusCritical: 2,
+}
+
+// IsCheck reports whether q reports a check monitoring result instead of metrics.
+func (q *Query) IsCheck() bool {
+	return q.Check != nil
+}
+
+// CheckName returns the name of the check monitoring.
+func (q *Query) CheckName() string {
+	if q.Check != nil && q.Check.Name != "" {
+		return q.Check.Name
+	}
+	return q.GetName()
+}
+
+// CheckWithContext runs q and returns the check report.
+// Source of the report is nil if q.Check.Host is empty.
+// If it failed, it returns the error and the report whose status is UNKNOWN.
+func (q *Query) CheckWithContext(ctx context.Context, db *sql.DB, logger logr.Logger) (*mackerel.CheckReport, error) {
+	logger = logger.WithValues("query", q.GetName())
+	r := &mackerel.CheckReport{
+		Name:                 q.CheckName(),
+		OccurredAt:           nowFunc().Unix(),
+		NotificationInterval: q.Check.NotificationInterval,
+		MaxCheckAttempts:     q.Check.MaxCheckAttempts,
+	}
+	if q.Check.Host != "" {
+		r.Source = mackerel.NewCheckSourceHost(q.Check.Host)
+	}
+
+	status, message, err := q.checkWithContext(ctx, db, logger)
+	if err != nil {
+		if name := q.GetName(); name != "" {
+			err = fmt.Errorf("query %s: %w", name, err)
+		}
+		if q.Source != "" {
+			err = fmt.Errorf("%s: %w", q.Source, err)
+		}
+		r.Status = mackerel.CheckStatusUnknown
+		r.Message = query.TruncateCheckMessage(err.Error())
+		return r, err
+	}
+	r.Status = status
+	r.Message = query.TruncateCheckMessage(message)
+	return r, nil
+}
+
+func (q *Query) checkWithContext(ctx context.Context, db *sql.DB, logger logr.Logger) (mackerel.CheckStatus, string, error) {
+	status := mackerel.CheckStatusOK
+	var (
+		n     int
+		lines [3][]string // messages of rows that are not OK, by the level of status
+	)
+	err := q.queryDBWithContext(ctx, db, logger, func(r dbRow) error {
+		v, ok := r[q.Check.Value]
+		if !ok {
+			return fmt.Errorf("%q not exists in columns", q.Check.Value)
+		}
+		if v == nil {
+			return nil
+		}
+		mv, err := toMetricValue(v)
+		if err != nil {
+			return fmt.Errorf("column %q: %w", q.Check.Value, err)
+		}
+		f, ok := toFloat64(mv)
+		if !ok {
+			return fmt.Errorf("column %q: failed to convert %v to float64", q.Check.Value, mv)
+		}
+		n++
+		s := q.Check.status(f)
+		if s == mackerel.CheckStatusOK {
+			return nil
+		}
+		if checkStatusLevels[s] > checkStatusLevels[status] {
+			status = s
+		}
+		level := checkStatusLevels[s]
+		lines[level] = append(lines[level], fmt.Sprintf("[%s] %s", s, q.checkMessage(r)))
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	// Messages of the worst rows are placed first because the message may be truncated.
+	var a []string
+	for i := len(lines) - 1; i >= 0; i-- {
+		a = append(a, lines[i]...)
+	}
+	switch {
+	case n == 0:
+		return status, "no rows", nil
+	case len(a) == 0:
+		return status, fmt.Sprintf("%d rows are OK", n), nil
+	}
+	return status, strings.Join(a, "\n"), nil
+}
+
+// checkMessage returns q.Check.Message whose #{column} are replaced with values in r.
+func (q *Query) checkMessage(r dbRow) string {
+	if q.Check.Message == "" {
+		return fmt.Sprintf("%s = %v", q.Check.Value, r[q.Check.Value])
+	}
+	return ValueKeyRE.ReplaceAllStringFunc(q.Check.Message, func(match string) string {
+		col := ValueKeyRE.FindStringSubmatch(match)[1]
+		v, ok := r[col]
+		switch {
+		case !ok:
+			return match
+		case v == nil:
+			return "NULL"
+		default:
+			return fmt.Sprintf("%v", v)
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package valuekey
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const defaultCommandTimeout = 30 * time.Second
+
+// Command configures how `$(...)` in params is evaluated.
+type Command struct {
+	// Timeout limits the execution time of each command. Zero means defaultCommandTimeout.
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" toml:"timeout,omitempty"`
+
+	// Dir is the working directory of commands. Empty means the current directory.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty" toml:"dir,omitempty"`
+
+	// Env is the environment of commands.
+	// "NAME" passes through the collector's variable, and "NAME=VALUE" sets it explicitly.
+	// When Env is empty, commands inherit all variables of the collector.
+	Env []string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty"`
+}
+
+// UnmarshalJSON accepts Timeout as a duration string such as "10s", or a number of seconds.
+func (c *Command) UnmarshalJSON(data []byte) error {
+	type command Command
+	var v struct {
+		*command
+		Timeout any `json:"timeout,omitempty"`
+	}
+	v.command = (*command)(c)
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return c.setTimeout(v.Timeout)
+}
+
+// UnmarshalYAML accepts Timeout as a duration string such as "10s", or a number of seconds.
+func (c *Command) UnmarshalYAML(unmarshal func(any) error) error {
+	var v struct {
+		Timeout any      `yaml:"timeout,omitempty"`
+		Dir     string   `yaml:"dir,omitempty"`
+		Env     []string `yaml:"env,omitempty"`
+	}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	c.Dir = v.Dir
+	c.Env = v.Env
+	return c.setTimeout(v.Timeout)
+}
+
+// UnmarshalTOML accepts Timeout as a duration string such as "10s", or a number of seconds.
+func (c *Command) UnmarshalTOML(data any) error {
+	m, ok := data.(map[string]any)
+	if !ok {
+		return fmt.Errorf("command must be a table: %v", data)
+	}
+	for k, v := range m {
+		switch k {
+		case "timeout":
+			if err := c.setTimeout(v); err != nil {
+				return err
+			}
+		case "dir":
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("command.dir must be a string: %v", v)
+			}
+			c.Dir = s
+		case "env":
+			a, ok := v.([]any)
+			if !ok {
+				return fmt.Errorf("command.env must be an array: %v", v)
+			}
+			c.Env = make([]string, len(a))
+			for i, e := range a {
+				s, ok := e.(string)
+				if !ok {
+					return fmt.Errorf("command.env must be an array of strings: %v", v)
+				}
+				c.Env[i] = s
+			}
+		default:
+			return fmt.Errorf("unknown field %q in command", k)
+		}
+	}
+	return nil
+}
+
+// setTimeout sets Timeout from a duration string or a number of seconds.
+func (c *Command) setTimeout(v any) error {
+	switch t := v.(type) {
+	case nil:
+	case int:
+		c.Timeout = time.Duration(t) * time.Second
+	case int64:
+		c.Timeout = time.Duration(t) * time.Second
+	case uint64:
+		c.Timeout = time.Duration(t) * time.Second
+	case float64:
+		c.Timeout = time.Duration(t * float64(time.Second))
+	case string:
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return err
+		}
+		c.Timeout = d
+	default:
+		return fmt.Errorf("timeout must be a duration string or a number of seconds: %v", t)
+	}
+	return nil
+}
+
+func (c *Command) timeout() time.Duration {
+	if c == nil || c.Timeout <= 0 {
+		return defaultCommandTimeout
+	}
+	return c.Timeout
+}
+
+func (c *Command) dir() string {
+	if c == nil {
+		return ""
+	}
+	return c.Dir
+}
+
+func (c *Command) environ() []string {
+	if c == nil || len(c.Env) == 0 {
+		return nil // inherit the environment of the collector
+	}
+
+	env := make([]string, 0, len(c.Env))
+	for _, s := range c.Env {
+		if strings.Contains(s, "=") {
+			env = append(env, s)
+			continue
+		}
+		if v, ok := os.LookupEnv(s); ok {
+			env = append(env, s+"="+v)
+		}
+	}
+	return env
+}
+
+// run executes the command line with /bin/sh and returns its trimmed stdout.
+func (c *Command) run(ctx context.Context, line string, logger logr.Logger) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", line)
+	cmd.Dir = c.dir()
+	cmd.Env = c.environ()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait forever for grandchildren that keep the output pipes open after the shell is killed.
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	errOut := strings.TrimSpace(stderr.String())
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %v: %w", c.timeout(), err)
+		}
+		logger.Error(err, "failed to execute command", "command", line, "stderr", errOut)
+		if errOut != "" {
+			return "", fmt.Errorf("command %q: %w: %s", line, err, errOut)
+		}
+		return "", fmt.Errorf("command %q: %w", line, err)
+	}
+	if errOut != "" {
+		logger.Info("command wrote to stderr", "command", line, "stderr", errOut)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
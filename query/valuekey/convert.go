@@ -0,0 +1,251 @@
+package valuekey
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columnConverter converts a scanned value into a normalized Go value.
+type columnConverter func(v any) (any, error)
+
+// Database type names reported by drivers via sql.ColumnType.DatabaseTypeName.
+// They are compared in upper case and without length or precision such as "(10,2)".
+var columnConverters = map[string]columnConverter{
+	"SMALLINT": convertInt, "INT": convertInt, "INTEGER": convertInt, "BIGINT": convertInt,
+	"TINYINT": convertInt, "MEDIUMINT": convertInt, "INT2": convertInt, "INT4": convertInt,
+	"INT8": convertInt, "INT64": convertInt, "SERIAL": convertInt, "BIGSERIAL": convertInt,
+
+	"UNSIGNED TINYINT": convertInt, "UNSIGNED SMALLINT": convertInt, "UNSIGNED MEDIUMINT": convertInt,
+	"UNSIGNED INT": convertInt, "UNSIGNED BIGINT": convertInt,
+
+	"REAL": convertFloat, "FLOAT": convertFloat, "DOUBLE": convertFloat, "DOUBLE PRECISION": convertFloat,
+	"FLOAT4": convertFloat, "FLOAT8": convertFloat, "FLOAT64": convertFloat,
+
+	"NUMERIC": convertDecimal, "DECIMAL": convertDecimal, "BIGNUMERIC": convertDecimal, "BIGDECIMAL": convertDecimal,
+
+	"BOOL": convertBool, "BOOLEAN": convertBool,
+
+	"DATE": convertTime, "DATETIME": convertTime, "TIMESTAMP": convertTime,
+	"TIMESTAMPTZ": convertTime, "TIMESTAMP WITH TIME ZONE": convertTime,
+
+	"CHAR": convertString, "VARCHAR": convertString, "TEXT": convertString, "STRING": convertString,
+	"BPCHAR": convertString, "NAME": convertString, "UUID": convertString,
+	"TINYTEXT": convertString, "MEDIUMTEXT": convertString, "LONGTEXT": convertString,
+}
+
+// lookupColumnConverter returns the converter for the database type.
+// It returns convertLegacy for unknown types.
+func lookupColumnConverter(typeName string) columnConverter {
+	typeName, _, _ = strings.Cut(strings.ToUpper(strings.TrimSpace(typeName)), "(")
+	if c, ok := columnConverters[strings.TrimSpace(typeName)]; ok {
+		return c
+	}
+	return convertLegacy
+}
+
+// convertLegacy converts []byte into int, float64 if possible, otherwise string.
+func convertLegacy(v any) (any, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return v, nil
+	}
+	s := string(b)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+func convertString(v any) (any, error) {
+	switch v := v.(type) {
+	case []byte:
+		return string(v), nil
+	default:
+		return v, nil
+	}
+}
+
+func convertInt(v any) (any, error) {
+	switch v := v.(type) {
+	case []byte:
+		return parseInt(string(v))
+	case string:
+		return parseInt(v)
+	default:
+		return v, nil
+	}
+}
+
+// parseInt parses s as int64, or uint64 if it overflows int64.
+func parseInt(s string) (any, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func convertFloat(v any) (any, error) {
+	switch v := v.(type) {
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return v, nil
+	}
+}
+
+// convertDecimal converts exact numbers into int64 if they are integers within int64, otherwise float64.
+func convertDecimal(v any) (any, error) {
+	var r *big.Rat
+	switch v := v.(type) {
+	case []byte:
+		return parseDecimal(string(v))
+	case string:
+		return parseDecimal(v)
+	case *big.Rat:
+		r = v
+	case big.Rat:
+		r = &v
+	default:
+		return v, nil
+	}
+	if r == nil {
+		return nil, nil
+	}
+	return ratValue(r), nil
+}
+
+func parseDecimal(s string) (any, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	return ratValue(r), nil
+}
+
+func ratValue(r *big.Rat) any {
+	if r.IsInt() && r.Num().IsInt64() {
+		return r.Num().Int64()
+	}
+	f, _ := r.Float64()
+	return f
+}
+
+func convertBool(v any) (any, error) {
+	switch v := v.(type) {
+	case []byte:
+		return strconv.ParseBool(string(v))
+	case string:
+		return strconv.ParseBool(v)
+	case int64:
+		return v != 0, nil
+	default:
+		return v, nil
+	}
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+func convertTime(v any) (any, error) {
+	var s string
+	switch v := v.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return v, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time %q", s)
+}
+
+// toMetricValue converts a column value into a type that can be posted as a metric value.
+func toMetricValue(v any) (any, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return uintValue(uint64(v)), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return uintValue(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case time.Time:
+		return v.Unix(), nil
+	case *big.Rat:
+		return ratValue(v), nil
+	case big.Rat:
+		return ratValue(&v), nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, nil
+	case *big.Float:
+		f, _ := v.Float64()
+		return f, nil
+	case []byte:
+		return parseMetricValue(string(v))
+	case string:
+		return parseMetricValue(v)
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+func uintValue(v uint64) any {
+	if v > math.MaxInt64 {
+		return float64(v)
+	}
+	return int64(v)
+}
+
+// parseMetricValue parses numbers typed as strings such as results of Athena.
+func parseMetricValue(s string) (any, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if r, ok := new(big.Rat).SetString(s); ok {
+		return ratValue(r), nil
+	}
+	return nil, fmt.Errorf("cannot convert %q to a number", s)
+}
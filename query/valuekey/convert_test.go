@@ -0,0 +1,101 @@
+package valuekey
+
+import (
+	"io"
+	"log"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-logr/stdr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestColumnConverters(t *testing.T) {
+	testCases := []struct {
+		typeName string
+		value    any
+		want     any
+	}{
+		{"VARCHAR", []byte("0123"), "0123"},
+		{"", []byte("0123"), int64(123)},
+		{"", []byte("1.5"), 1.5},
+		{"", []byte("abc"), "abc"},
+		{"BIGINT", []byte("42"), int64(42)},
+		{"UNSIGNED BIGINT", []byte("18446744073709551615"), uint64(18446744073709551615)},
+		{"DOUBLE", []byte("0.25"), 0.25},
+		{"DECIMAL(10,2)", []byte("12.50"), 12.5},
+		{"numeric", []byte("123456789012345678901234567890"), 1.2345678901234568e+29},
+		{"NUMERIC", []byte("100.000"), int64(100)},
+		{"BIGNUMERIC", big.NewRat(3, 2), 1.5},
+		{"BOOL", []byte("true"), true},
+		{"DATETIME", []byte("2022-01-02 03:04:05"), time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		got, err := lookupColumnConverter(tc.typeName)(tc.value)
+		if err != nil {
+			t.Errorf("convert(%s, %v): %v", tc.typeName, tc.value, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("convert(%s, %v): (-want, +got)\n%s", tc.typeName, tc.value, diff)
+		}
+	}
+}
+
+func TestToMetricValue(t *testing.T) {
+	testCases := []struct {
+		value any
+		want  any
+	}{
+		{int(1), int64(1)},
+		{uint64(18446744073709551615), float64(18446744073709551615)},
+		{float32(0.5), 0.5},
+		{true, int64(1)},
+		{time.Unix(1641092645, 0), int64(1641092645)},
+		{big.NewRat(1, 4), 0.25},
+		{" 10 ", int64(10)},
+		{"1.5e3", int64(1500)},
+	}
+	for _, tc := range testCases {
+		got, err := toMetricValue(tc.value)
+		if err != nil {
+			t.Errorf("toMetricValue(%v): %v", tc.value, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("toMetricValue(%v): (-want, +got)\n%s", tc.value, diff)
+		}
+	}
+
+	if _, err := toMetricValue("abc"); err == nil {
+		t.Errorf("toMetricValue(abc): want an error")
+	}
+}
+
+func TestQueryExecuteUnconvertibleValue(t *testing.T) {
+	logger := stdr.New(log.New(io.Discard, "", 0))
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("sqlmock.New: ", err)
+	}
+	t.Cleanup(func() {
+		db.Close() // nolint
+	})
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("name").OfType("VARCHAR", ""),
+		sqlmock.NewColumn("status").OfType("VARCHAR", ""),
+	).AddRow([]byte("a"), []byte("active"))
+	mock.ExpectQuery("SELECT (.+) FROM (.+)").WillReturnRows(rows)
+
+	q := &Query{
+		ValueKey: map[string]string{"#{name}": "status"},
+		SQL:      "SELECT * FROM dummy",
+	}
+	_, err = q.Execute(db, logger)
+	if err == nil || !strings.Contains(err.Error(), `column "status"`) {
+		t.Errorf("Execute: got %v; want an error naming the column", err)
+	}
+}
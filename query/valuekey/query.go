@@ -1,33 +1,85 @@
 package valuekey
 
 import (
+	"container/heap"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"os/exec"
+	"maps"
 	"regexp"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/mackerelio-labs/mackerel-sql-metric-collector/query"
 	"github.com/mackerelio/mackerel-client-go"
 )
 
-var valueKeyRE = regexp.MustCompile(`#\{([a-z\_]+)\}`)
+// ValueKeyRE matches references to columns such as #{column} in metric names.
+var ValueKeyRE = regexp.MustCompile(`#\{([a-z\_]+)\}`)
+
+// MetricNameRE matches metric names that Mackerel accepts.
+var MetricNameRE = regexp.MustCompile(`\A[-a-zA-Z0-9_]+(\.[-a-zA-Z0-9_]+)*\z`)
+
 var invalidMackerelMetricKeyCharsRE = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
 var commandExecRE = regexp.MustCompile(`\A\$\((.*)\)\z`)
 
+// Policies when a query returns more than Query.MaxRows rows.
+const (
+	// MaxRowsPolicyError aborts the query. This is the default.
+	MaxRowsPolicyError = "error"
+	// MaxRowsPolicyTruncate ignores the rest of rows with a warning.
+	MaxRowsPolicyTruncate = "truncate"
+)
+
+// Policies for series over Query.MaxSeries.
+const (
+	// SeriesOverflowDrop drops series over the limit. This is the default.
+	SeriesOverflowDrop = "drop"
+	// SeriesOverflowError aborts the query.
+	SeriesOverflowError = "error"
+	// SeriesOverflowOther sums series over the limit into the series whose #{column} are replaced with "other".
+	// Series whose keys have no #{column} are dropped.
+	SeriesOverflowOther = "other"
+)
+
 // Query represents ...
 type Query struct {
-	KeyPrefix    string             `yaml:"keyPrefix"`
-	ValueKey     map[string]string  `yaml:"valueKey"`
-	DefaultValue map[string]float64 `yaml:"defaultValue,omitempty"`
-	SQL          string             `yaml:"sql"`
-	Params       []any              `yaml:"params"`
-	Service      string             `yaml:"service,omitempty"`
-	Time         string             `yaml:"time"`
+	Name         string             `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty"`
+	Tags         []string           `yaml:"tags,omitempty" json:"tags,omitempty" toml:"tags,omitempty"`
+	Enabled      *bool              `yaml:"enabled,omitempty" json:"enabled,omitempty" toml:"enabled,omitempty"`
+	KeyPrefix    string             `yaml:"keyPrefix" json:"keyPrefix" toml:"keyPrefix"`
+	ValueKey     map[string]string  `yaml:"valueKey" json:"valueKey" toml:"valueKey"`
+	DefaultValue map[string]float64 `yaml:"defaultValue,omitempty" json:"defaultValue,omitempty" toml:"defaultValue,omitempty"`
+	SQL          string             `yaml:"sql" json:"sql" toml:"sql"`
+	Params       []any              `yaml:"params" json:"params" toml:"params"`
+	Command      *Command           `yaml:"command,omitempty" json:"command,omitempty" toml:"command,omitempty"`
+	Service      string             `yaml:"service,omitempty" json:"service,omitempty" toml:"service,omitempty"`
+	Time         string             `yaml:"time" json:"time" toml:"time"`
+
+	// Exporters limits exporters that metrics are sent to. Empty means all exporters.
+	Exporters []string `yaml:"exporters,omitempty" json:"exporters,omitempty" toml:"exporters,omitempty"`
+
+	// Check makes the query report a check monitoring result instead of metrics.
+	Check *Check `yaml:"check,omitempty" json:"check,omitempty" toml:"check,omitempty"`
+
+	// TopN sums metrics of rows except the largest ones into a bucket.
+	TopN *TopN `yaml:"topN,omitempty" json:"topN,omitempty" toml:"topN,omitempty"`
+
+	// MaxSeries limits the number of metric names generated. Zero means unlimited.
+	MaxSeries int `yaml:"maxSeries,omitempty" json:"maxSeries,omitempty" toml:"maxSeries,omitempty"`
+	// SeriesOverflow is what to do with series over MaxSeries.
+	SeriesOverflow string `yaml:"seriesOverflow,omitempty" json:"seriesOverflow,omitempty" toml:"seriesOverflow,omitempty"`
+
+	// MaxRows limits the number of rows processed. Zero means unlimited.
+	MaxRows int `yaml:"maxRows,omitempty" json:"maxRows,omitempty" toml:"maxRows,omitempty"`
+	// MaxRowsPolicy is what to do when the query returns more than MaxRows rows.
+	MaxRowsPolicy string `yaml:"maxRowsPolicy,omitempty" json:"maxRowsPolicy,omitempty" toml:"maxRowsPolicy,omitempty"`
+
+	// Source is the location of the file that defines the query.
+	Source string `yaml:"-" json:"-" toml:"-"`
 }
 
 // Execute is ...
@@ -39,145 +91,395 @@ var nowFunc = time.Now
 
 // ExecuteWithContext is ...
 func (q *Query) ExecuteWithContext(ctx context.Context, db *sql.DB, logger logr.Logger) ([]*mackerel.MetricValue, error) {
-	rows, err := q.queryDBWithContext(ctx, db)
+	logger = logger.WithValues("query", q.GetName())
+	metrics, err := q.executeWithContext(ctx, db, logger)
 	if err != nil {
+		if name := q.GetName(); name != "" {
+			err = fmt.Errorf("query %s: %w", name, err)
+		}
+		if q.Source != "" {
+			err = fmt.Errorf("%s: %w", q.Source, err)
+		}
 		return nil, err
 	}
+	return metrics, nil
+}
+
+// series is a metric value with the key that generates it.
+type series struct {
+	key   string
+	value any
+}
 
+func (q *Query) executeWithContext(ctx context.Context, db *sql.DB, logger logr.Logger) ([]*mackerel.MetricValue, error) {
 	metricCap := max(len(q.ValueKey), len(q.DefaultValue))
 	metrics := make([]*mackerel.MetricValue, 0, metricCap)
 	metricNames := make(map[string]struct{}, metricCap)
+	vs := make(map[string]series, metricCap)
 	now := nowFunc().Unix()
 
-	for _, r := range rows {
-		vs := make(map[string]any, metricCap)
+	overflow := make(map[string]struct{})
+	others := make(map[string]*mackerel.MetricValue)
+	if q.TopN != nil {
+		// The buckets are always posted to keep dashboards stable.
+		for k := range q.ValueKey {
+			if ValueKeyRE.MatchString(k) {
+				name := q.metricName(ValueKeyRE.ReplaceAllString(k, q.TopN.othersKey()))
+				others[name] = &mackerel.MetricValue{Name: name, Value: float64(0)}
+			}
+		}
+	}
+
+	// process converts r into metrics. If rest is true, r is out of q.TopN and its values are summed into buckets.
+	process := func(r dbRow, rest bool) error {
+		clear(vs)
 
-		for k, v := range q.DefaultValue {
-			vk, err := replaceValueKey(k, r)
+		t := now
+		if q.Time != "" {
+			value, ok := r[q.Time]
+			if !ok {
+				return fmt.Errorf("%q not exists in columns", q.Time)
+			}
+			tv, err := toMetricValue(value)
 			if err != nil {
-				logger.Info(err.Error(), "query", q)
-				continue
+				return fmt.Errorf("column %q: %w", q.Time, err)
+			}
+			t, ok = tv.(int64)
+			if !ok {
+				return fmt.Errorf("failed to convert %q to int64", q.Time)
+			}
+		}
+
+		if !rest {
+			for k, v := range q.DefaultValue {
+				vk, err := replaceValueKey(k, r)
+				if err != nil {
+					logger.Info(err.Error())
+					continue
+				}
+				vs[vk] = series{key: k, value: v}
 			}
-			vs[vk] = v
 		}
 		for k, v := range q.ValueKey {
-			var err error
+			if rest && !ValueKeyRE.MatchString(k) {
+				continue
+			}
 
 			vk, err := replaceValueKey(k, r)
 			if err != nil {
-				logger.Info(err.Error(), "query", q)
+				logger.Info(err.Error())
 				continue
 			}
 
 			value, ok := r[v]
 			if !ok {
-				return nil, fmt.Errorf("%q not exists in columns", v)
+				return fmt.Errorf("%q not exists in columns", v)
 			}
 			if value == nil {
 				continue
 			}
-			vs[vk] = value
-		}
-
-		t := now
-		if q.Time != "" {
-			value, ok := r[q.Time]
-			if !ok {
-				return nil, fmt.Errorf("%q not exists in columns", q.Time)
+			mv, err := toMetricValue(value)
+			if err != nil {
+				return fmt.Errorf("column %q: %w", v, err)
 			}
-			t, ok = value.(int64)
-			if !ok {
-				return nil, fmt.Errorf("failed to convert %q to int64", q.Time)
+			if rest {
+				addOther(others, q.metricName(ValueKeyRE.ReplaceAllString(k, q.TopN.othersKey())), mv, t)
+				continue
 			}
+			vs[vk] = series{key: k, value: mv}
 		}
-		for k, v := range vs {
-			name := k
-			if q.KeyPrefix != "" {
-				name = fmt.Sprintf("%s.%s", q.KeyPrefix, name)
-			}
+
+		for _, k := range slices.Sorted(maps.Keys(vs)) {
+			v := vs[k]
+			name := q.metricName(k)
 
 			if _, has := metricNames[name]; has {
 				continue
-			} else {
-				metricNames[name] = struct{}{}
 			}
+			if q.MaxSeries > 0 && len(metricNames) >= q.MaxSeries {
+				if _, has := overflow[name]; has {
+					continue
+				}
+				overflow[name] = struct{}{}
+				switch q.SeriesOverflow {
+				case SeriesOverflowError:
+					return fmt.Errorf("query generated more than %d series", q.MaxSeries)
+				case SeriesOverflowOther:
+					// Series without #{column} have no bucket to be summed into, so they are dropped.
+					if ValueKeyRE.MatchString(v.key) {
+						addOther(others, q.metricName(ValueKeyRE.ReplaceAllString(v.key, otherKey)), v.value, t)
+					}
+				}
+				continue
+			}
+			metricNames[name] = struct{}{}
 
 			mv := mackerel.MetricValue{
 				Name:  name,
-				Value: v,
+				Value: v.value,
 				Time:  t,
 			}
 			metrics = append(metrics, &mv)
 		}
+		return nil
+	}
+
+	var err error
+	if q.TopN == nil {
+		err = q.queryDBWithContext(ctx, db, logger, func(r dbRow) error {
+			return process(r, false)
+		})
+	} else {
+		err = q.topNWithContext(ctx, db, logger, process)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(others)) {
+		if _, has := metricNames[name]; has {
+			logger.Info("the bucket of other series conflicts with an existing series; it is dropped", "name", name)
+			continue
+		}
+		m := others[name]
+		if m.Time == 0 {
+			m.Time = now
+		}
+		metrics = append(metrics, m)
+	}
+	if len(overflow) > 0 {
+		logger.Info("query generated too many series", "maxSeries", q.MaxSeries, "overflow", len(overflow), "policy", q.SeriesOverflow)
+		metrics = append(metrics, &mackerel.MetricValue{
+			Name:  query.OverflowSeriesMetricName(q.GetName()),
+			Value: int64(len(overflow)),
+			Time:  now,
+		})
 	}
 
 	return metrics, nil
 }
 
+// topNWithContext calls fn with rows out of q.TopN as they are read, then with the top rows sorted by q.TopN.By.
+// Only q.TopN.Limit rows are held in memory.
+func (q *Query) topNWithContext(ctx context.Context, db *sql.DB, logger logr.Logger, fn func(r dbRow, rest bool) error) error {
+	var top topNHeap
+	seq := 0
+	err := q.queryDBWithContext(ctx, db, logger, func(r dbRow) error {
+		rr, err := q.TopN.rank(r, seq)
+		if err != nil {
+			return err
+		}
+		seq++
+		if len(top) < q.TopN.Limit {
+			rr.row = maps.Clone(r)
+			heap.Push(&top, rr)
+			return nil
+		}
+		if len(top) == 0 || compareRank(rr, top[0]) < 0 {
+			return fn(r, true)
+		}
+		rr.row = maps.Clone(r)
+		lowest := top[0]
+		top[0] = rr
+		heap.Fix(&top, 0)
+		return fn(lowest.row, true)
+	})
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(top, func(a, b rankedRow) int {
+		return compareRank(b, a)
+	})
+	for _, rr := range top {
+		if err := fn(rr.row, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otherKey is the name that replaces #{column} in the keys of overflowed series.
+const otherKey = "other"
+
+// addOther adds v into the bucket named name in others.
+func addOther(others map[string]*mackerel.MetricValue, name string, v any, t int64) {
+	f, _ := toFloat64(v)
+	if m, ok := others[name]; ok {
+		m.Value = m.Value.(float64) + f
+		m.Time = max(m.Time, t)
+		return
+	}
+	others[name] = &mackerel.MetricValue{Name: name, Value: f, Time: t}
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// metricName returns the metric name of key prefixed with q.KeyPrefix.
+// Validate returns an error if q has invalid values that cannot be executed.
+func (q *Query) Validate() error {
+	switch q.SeriesOverflow {
+	case "", SeriesOverflowDrop, SeriesOverflowError, SeriesOverflowOther:
+	default:
+		return fmt.Errorf("seriesOverflow must be %q, %q or %q: %q", SeriesOverflowDrop, SeriesOverflowError, SeriesOverflowOther, q.SeriesOverflow)
+	}
+	return nil
+}
+
+func (q *Query) metricName(key string) string {
+	if q.KeyPrefix == "" {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", q.KeyPrefix, key)
+}
+
+// GetName returns the name of q. It falls back to KeyPrefix if Name is empty.
+func (q *Query) GetName() string {
+	if q.Name != "" {
+		return q.Name
+	}
+	return q.KeyPrefix
+}
+
+// GetKeyPrefix returns the prefix of metric names posted by q.
+func (q *Query) GetKeyPrefix() string {
+	return q.KeyPrefix
+}
+
+// IsEnabled reports whether q is enabled. Queries are enabled unless Enabled is false.
+func (q *Query) IsEnabled() bool {
+	return q.Enabled == nil || *q.Enabled
+}
+
+// HasTag reports whether q has any of tags.
+func (q *Query) HasTag(tags ...string) bool {
+	for _, t := range tags {
+		if slices.Contains(q.Tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetExporters returns names of exporters that metrics of q are sent to.
+func (q *Query) GetExporters() []string {
+	return q.Exporters
+}
+
+// StatementWithContext returns q.SQL and params that are evaluated.
+func (q *Query) StatementWithContext(ctx context.Context, logger logr.Logger) (string, []any, error) {
+	params, err := evalParams(ctx, q.Params, q.Command, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	return q.SQL, params, nil
+}
+
+// MetricNames returns the sorted names of metrics built from q.ValueKey and q.DefaultValue.
+func (q *Query) MetricNames() []string {
+	keys := slices.Concat(slices.Collect(maps.Keys(q.ValueKey)), slices.Collect(maps.Keys(q.DefaultValue)))
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, q.metricName(k))
+	}
+	if q.TopN != nil {
+		for k := range q.ValueKey {
+			if ValueKeyRE.MatchString(k) {
+				names = append(names, q.metricName(ValueKeyRE.ReplaceAllString(k, q.TopN.othersKey())))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
 // GetService is ...
 func (q *Query) GetService() string {
 	return q.Service
 }
 
+// dbRow is a row of query results keyed by column names.
+// It is reused for each row, so callbacks must not retain it.
 type dbRow map[string]any
 
-func (q *Query) queryDBWithContext(ctx context.Context, db *sql.DB) ([]dbRow, error) {
-	params, err := evalParams(q.Params)
+// queryDBWithContext executes q.SQL and calls fn with each row as it is scanned.
+func (q *Query) queryDBWithContext(ctx context.Context, db *sql.DB, logger logr.Logger, fn func(dbRow) error) error {
+	params, err := evalParams(ctx, q.Params, q.Command, logger)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	rows, err := db.QueryContext(ctx, q.SQL, params...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close() // nolint
 
 	cols, err := rows.Columns()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	converters := make([]columnConverter, len(cols))
+	for i, t := range types {
+		converters[i] = lookupColumnConverter(t.DatabaseTypeName())
 	}
 
-	var results []dbRow
+	var row = make([]any, len(cols))
+	var rowp = make([]any, len(cols)) // Slice pointer to each column of row (row[i]).
+	for i := 0; i < len(row); i++ {
+		rowp[i] = &row[i]
+	}
+	rowMap := make(dbRow, len(cols))
 
+	var nrows int
 	for rows.Next() {
-		var row = make([]any, len(cols))
-		var rowp = make([]any, len(cols)) // Slice pointer to each column of row (row[i]).
-		for i := 0; i < len(row); i++ {
-			rowp[i] = &row[i]
+		if q.MaxRows > 0 && nrows >= q.MaxRows {
+			if q.MaxRowsPolicy != MaxRowsPolicyTruncate {
+				return fmt.Errorf("query returned more than %d rows", q.MaxRows)
+			}
+			logger.Info("query returned too many rows; the rest of rows are ignored", "maxRows", q.MaxRows)
+			break
 		}
+		nrows++
 
 		err := rows.Scan(rowp...) // Pass a slice of column pointers as a parameters.
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		rowMap := make(dbRow)
 		for i, col := range cols {
-			switch row[i].(type) {
-			case []byte:
-				row[i] = string(row[i].([]byte))
-
-				n, err := strconv.Atoi(row[i].(string))
-				if err == nil {
-					row[i] = n
-				} else {
-					f, err := strconv.ParseFloat(row[i].(string), 64)
-					if err == nil {
-						row[i] = f
-					}
-				}
+			if row[i] == nil {
+				rowMap[col] = nil
+				continue
+			}
+			v, err := converters[i](row[i])
+			if err != nil {
+				return fmt.Errorf("column %q (%s): %w", col, types[i].DatabaseTypeName(), err)
 			}
-			rowMap[col] = row[i]
+			rowMap[col] = v
 		}
 
-		results = append(results, rowMap)
+		if err := fn(rowMap); err != nil {
+			return err
+		}
 	}
 
-	return results, nil
+	return rows.Err()
 }
 
-func evalParams(params []any) ([]any, error) {
+func evalParams(ctx context.Context, params []any, c *Command, logger logr.Logger) ([]any, error) {
 	evaluated := make([]any, len(params))
 
 	for i, p := range params {
@@ -187,31 +489,13 @@ func evalParams(params []any) ([]any, error) {
 			continue
 		}
 
-		var err error
-
-		v = commandExecRE.ReplaceAllStringFunc(v, func(cmd string) string {
-			matches := commandExecRE.FindStringSubmatch(cmd)
-
-			if len(matches) != 2 {
-				err = errors.New("command not found")
-				return ""
-			}
-
-			cmd = matches[1]
-
-			out, e := exec.Command("/bin/sh", "-c", cmd).Output()
-			if e != nil {
-				if err == nil {
-					err = e
-				}
-				return ""
+		matches := commandExecRE.FindStringSubmatch(v)
+		if len(matches) == 2 {
+			out, err := c.run(ctx, matches[1], logger)
+			if err != nil {
+				return nil, err
 			}
-
-			return strings.TrimSpace(string(out))
-		})
-
-		if err != nil {
-			return nil, err
+			v = out
 		}
 
 		evaluated[i] = v
@@ -223,8 +507,8 @@ func evalParams(params []any) ([]any, error) {
 func replaceValueKey(key string, row dbRow) (string, error) {
 	var err error
 
-	replaced := valueKeyRE.ReplaceAllStringFunc(key, func(match string) string {
-		matches := valueKeyRE.FindStringSubmatch(match)
+	replaced := ValueKeyRE.ReplaceAllStringFunc(key, func(match string) string {
+		matches := ValueKeyRE.FindStringSubmatch(match)
 
 		if len(matches) != 2 {
 			err = errors.New("ValueKey not found")
@@ -1,6 +1,8 @@
 package valuekey
 
 import (
+	"context"
+	"database/sql/driver"
 	"io"
 	"log"
 	"os"
@@ -155,3 +157,411 @@ func TestQueryExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestEvalParams(t *testing.T) {
+	t.Setenv("SECRET_VALUE", "secret")
+	t.Setenv("VISIBLE_VALUE", "visible")
+	logger := stdr.New(log.New(io.Discard, "", 0))
+
+	testCases := map[string]struct {
+		params  []any
+		command *Command
+		want    []any
+		wantErr string
+	}{
+		"literal": {
+			params: []any{1, "a", false},
+			want:   []any{1, "a", false},
+		},
+		"command": {
+			params: []any{"$(echo ' hello ')"},
+			want:   []any{"hello"},
+		},
+		"inherited_env": {
+			params: []any{"$(echo \"${SECRET_VALUE}\")"},
+			want:   []any{"secret"},
+		},
+		"sanitized_env": {
+			params:  []any{"$(echo \"${SECRET_VALUE}\")"},
+			command: &Command{Env: []string{"PATH"}},
+			want:    []any{""},
+		},
+		"allowed_env": {
+			params:  []any{"$(echo \"${VISIBLE_VALUE}-${FIXED}\")"},
+			command: &Command{Env: []string{"VISIBLE_VALUE", "FIXED=fixed"}},
+			want:    []any{"visible-fixed"},
+		},
+		"dir": {
+			params:  []any{"$(pwd)"},
+			command: &Command{Dir: "/"},
+			want:    []any{"/"},
+		},
+		"stderr": {
+			params:  []any{"$(echo oops >&2; exit 3)"},
+			wantErr: "oops",
+		},
+		"timeout": {
+			params:  []any{"$(sleep 10)"},
+			command: &Command{Timeout: 100 * time.Millisecond},
+			wantErr: "timed out",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := evalParams(context.Background(), tc.params, tc.command, logger)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("evalParams: got %v; want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evalParams: got %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("evalParams: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQueryExecuteMaxRows(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]struct {
+		policy  string
+		want    []string
+		wantErr bool
+	}{
+		"error": {
+			policy:  MaxRowsPolicyError,
+			wantErr: true,
+		},
+		"default": {
+			wantErr: true,
+		},
+		"truncate": {
+			policy: MaxRowsPolicyTruncate,
+			want:   []string{"agent.versions.0_1_0", "agent.versions.0_1_1"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			logger := stdr.New(log.New(io.Discard, "", 0))
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal("sqlmock.New: ", err)
+			}
+			t.Cleanup(func() {
+				db.Close() // nolint
+			})
+			columns := []string{"agent_version", "host_num"}
+			rows := sqlmock.NewRows(columns).AddRow("0.1.0", 10).AddRow("0.1.1", 20).AddRow("0.1.2", 30)
+			mock.ExpectQuery("SELECT (.+) FROM (.+)").WillReturnRows(rows)
+
+			q := &Query{
+				KeyPrefix: "agent",
+				ValueKey: map[string]string{
+					"versions.#{agent_version}": "host_num",
+				},
+				SQL:           "SELECT * FROM dummy",
+				MaxRows:       2,
+				MaxRowsPolicy: tc.policy,
+			}
+			values, err := q.Execute(db, logger)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Execute: want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute: got %v", err)
+			}
+			var names []string
+			for _, v := range values {
+				names = append(names, v.Name)
+			}
+			slices.Sort(names)
+			if diff := cmp.Diff(tc.want, names); diff != "" {
+				t.Errorf("Execute: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQueryExecuteMaxSeries(t *testing.T) {
+	t.Parallel()
+	now := nowFunc().Unix()
+	overflow := &mackerel.MetricValue{Name: "sql_metric_collector.overflow_series.versions", Value: int64(2), Time: now}
+	testCases := map[string]struct {
+		policy   string
+		valueKey map[string]string
+		want     []*mackerel.MetricValue
+		wantErr  bool
+	}{
+		"default": {
+			want: []*mackerel.MetricValue{
+				{Name: "agent.versions.0_1_0", Value: int64(10), Time: now},
+				overflow,
+			},
+		},
+		"drop": {
+			policy: SeriesOverflowDrop,
+			want: []*mackerel.MetricValue{
+				{Name: "agent.versions.0_1_0", Value: int64(10), Time: now},
+				overflow,
+			},
+		},
+		"error": {
+			policy:  SeriesOverflowError,
+			wantErr: true,
+		},
+		"other": {
+			policy: SeriesOverflowOther,
+			want: []*mackerel.MetricValue{
+				{Name: "agent.versions.0_1_0", Value: int64(10), Time: now},
+				{Name: "agent.versions.other", Value: float64(50), Time: now},
+				overflow,
+			},
+		},
+		"other_without_column": {
+			policy: SeriesOverflowOther,
+			valueKey: map[string]string{
+				"versions.#{agent_version}": "host_num",
+				"versions_total":            "host_num",
+			},
+			want: []*mackerel.MetricValue{
+				{Name: "agent.versions.0_1_0", Value: int64(10), Time: now},
+				{Name: "agent.versions.other", Value: float64(50), Time: now},
+				{Name: "sql_metric_collector.overflow_series.versions", Value: int64(3), Time: now},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			logger := stdr.New(log.New(io.Discard, "", 0))
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal("sqlmock.New: ", err)
+			}
+			t.Cleanup(func() {
+				db.Close() // nolint
+			})
+			columns := []string{"agent_version", "host_num"}
+			rows := sqlmock.NewRows(columns).AddRow("0.1.0", 10).AddRow("0.1.1", 20).AddRow("0.1.2", 30)
+			mock.ExpectQuery("SELECT (.+) FROM (.+)").WillReturnRows(rows)
+
+			valueKey := tc.valueKey
+			if valueKey == nil {
+				valueKey = map[string]string{"versions.#{agent_version}": "host_num"}
+			}
+			q := &Query{
+				Name:           "versions",
+				KeyPrefix:      "agent",
+				ValueKey:       valueKey,
+				SQL:            "SELECT * FROM dummy",
+				MaxSeries:      1,
+				SeriesOverflow: tc.policy,
+			}
+			values, err := q.Execute(db, logger)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Execute: want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute: got %v", err)
+			}
+			if diff := cmp.Diff(tc.want, values); diff != "" {
+				t.Errorf("Execute: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQueryExecuteTopN(t *testing.T) {
+	t.Parallel()
+	now := nowFunc().Unix()
+	testCases := map[string]struct {
+		topN *TopN
+		want []*mackerel.MetricValue
+	}{
+		"others": {
+			topN: &TopN{By: "user_num", Limit: 2},
+			want: []*mackerel.MetricValue{
+				{Name: "tenants.total", Value: int64(10), Time: now},
+				{Name: "tenants.users.c", Value: int64(40), Time: now},
+				{Name: "tenants.users.b", Value: int64(30), Time: now},
+				{Name: "tenants.users.others", Value: float64(30), Time: now},
+			},
+		},
+		"custom_others": {
+			topN: &TopN{By: "user_num", Limit: 1, Others: "rest"},
+			want: []*mackerel.MetricValue{
+				{Name: "tenants.total", Value: int64(10), Time: now},
+				{Name: "tenants.users.c", Value: int64(40), Time: now},
+				{Name: "tenants.users.rest", Value: float64(60), Time: now},
+			},
+		},
+		"null_last": {
+			topN: &TopN{By: "user_num", Limit: 4},
+			want: []*mackerel.MetricValue{
+				{Name: "tenants.total", Value: int64(10), Time: now},
+				{Name: "tenants.users.c", Value: int64(40), Time: now},
+				{Name: "tenants.users.b", Value: int64(30), Time: now},
+				{Name: "tenants.users.d", Value: int64(20), Time: now},
+				{Name: "tenants.users.a", Value: int64(10), Time: now},
+				{Name: "tenants.users.others", Value: float64(0), Time: now},
+			},
+		},
+		"no_others": {
+			topN: &TopN{By: "user_num", Limit: 10},
+			want: []*mackerel.MetricValue{
+				{Name: "tenants.total", Value: int64(10), Time: now},
+				{Name: "tenants.users.c", Value: int64(40), Time: now},
+				{Name: "tenants.users.b", Value: int64(30), Time: now},
+				{Name: "tenants.users.d", Value: int64(20), Time: now},
+				{Name: "tenants.users.a", Value: int64(10), Time: now},
+				{Name: "tenants.users.others", Value: float64(0), Time: now},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			logger := stdr.New(log.New(io.Discard, "", 0))
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal("sqlmock.New: ", err)
+			}
+			t.Cleanup(func() {
+				db.Close() // nolint
+			})
+			columns := []string{"tenant", "user_num", "total"}
+			rows := sqlmock.NewRows(columns).
+				AddRow("a", 10, 10).
+				AddRow("b", 30, 10).
+				AddRow("c", 40, 10).
+				AddRow("d", 20, 10).
+				AddRow("e", nil, 10)
+			mock.ExpectQuery("SELECT (.+) FROM (.+)").WillReturnRows(rows)
+
+			q := &Query{
+				KeyPrefix: "tenants",
+				ValueKey: map[string]string{
+					"users.#{tenant}": "user_num",
+					"total":           "total",
+				},
+				SQL:  "SELECT * FROM dummy",
+				TopN: tc.topN,
+			}
+			values, err := q.Execute(db, logger)
+			if err != nil {
+				t.Fatalf("Execute: got %v", err)
+			}
+			if diff := cmp.Diff(tc.want, values); diff != "" {
+				t.Errorf("Execute: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQueryCheck(t *testing.T) {
+	t.Parallel()
+	float := func(v float64) *float64 { return &v }
+	testCases := map[string]struct {
+		check *Check
+		rows  [][]driver.Value
+		want  *mackerel.CheckReport
+	}{
+		"critical": {
+			check: &Check{
+				Host:     "host1",
+				Value:    "lag",
+				Warning:  float(10),
+				Critical: float(60),
+				Message:  "#{replica} lags #{lag}s",
+			},
+			rows: [][]driver.Value{{"r1", 5}, {"r2", 90}, {"r3", 30}, {"r4", nil}},
+			want: &mackerel.CheckReport{
+				Source:     mackerel.NewCheckSourceHost("host1"),
+				Name:       "replication",
+				Status:     mackerel.CheckStatusCritical,
+				Message:    "[CRITICAL] r2 lags 90s\n[WARNING] r3 lags 30s",
+				OccurredAt: nowFunc().Unix(),
+			},
+		},
+		"less": {
+			check: &Check{
+				Name:     "replicas",
+				Value:    "lag",
+				Operator: CheckOperatorLess,
+				Warning:  float(10),
+			},
+			rows: [][]driver.Value{{"r1", 20}, {"r2", 5}},
+			want: &mackerel.CheckReport{
+				Name:       "replicas",
+				Status:     mackerel.CheckStatusWarning,
+				Message:    "[WARNING] lag = 5",
+				OccurredAt: nowFunc().Unix(),
+			},
+		},
+		"ok": {
+			check: &Check{Value: "lag", Warning: float(10)},
+			rows:  [][]driver.Value{{"r1", 5}, {"r2", 8}},
+			want: &mackerel.CheckReport{
+				Name:       "replication",
+				Status:     mackerel.CheckStatusOK,
+				Message:    "2 rows are OK",
+				OccurredAt: nowFunc().Unix(),
+			},
+		},
+		"no rows": {
+			check: &Check{Value: "lag", Warning: float(10)},
+			want: &mackerel.CheckReport{
+				Name:       "replication",
+				Status:     mackerel.CheckStatusOK,
+				Message:    "no rows",
+				OccurredAt: nowFunc().Unix(),
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			logger := stdr.New(log.New(io.Discard, "", 0))
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal("sqlmock.New: ", err)
+			}
+			t.Cleanup(func() {
+				db.Close() // nolint
+			})
+			rows := sqlmock.NewRows([]string{"replica", "lag"})
+			for _, r := range tc.rows {
+				rows.AddRow(r...)
+			}
+			mock.ExpectQuery("SELECT (.+) FROM (.+)").WillReturnRows(rows)
+
+			q := &Query{
+				Name:  "replication",
+				SQL:   "SELECT * FROM dummy",
+				Check: tc.check,
+			}
+			got, err := q.CheckWithContext(context.Background(), db, logger)
+			if err != nil {
+				t.Fatalf("CheckWithContext: got %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("CheckWithContext: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
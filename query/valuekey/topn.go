@@ -0,0 +1,86 @@
+package valuekey
+
+import (
+	"cmp"
+	"fmt"
+)
+
+const defaultTopNOthers = "others"
+
+// TopN keeps the largest rows as they are and sums the rest into a bucket.
+type TopN struct {
+	// By is the column that rows are sorted by in descending order.
+	By string `yaml:"by" json:"by" toml:"by"`
+
+	// Limit is the number of rows that keep their metric names.
+	Limit int `yaml:"limit" json:"limit" toml:"limit"`
+
+	// Others replaces #{column} in the metric names of the rest of rows. Empty means "others".
+	Others string `yaml:"others,omitempty" json:"others,omitempty" toml:"others,omitempty"`
+}
+
+func (n *TopN) othersKey() string {
+	if n.Others == "" {
+		return defaultTopNOthers
+	}
+	return n.Others
+}
+
+// rankedRow is a row with the value of the By column.
+type rankedRow struct {
+	row   dbRow
+	value float64
+	null  bool
+	seq   int // the order in which the row was read, to keep earlier rows on ties
+}
+
+// rank returns r with the value of the By column.
+func (n *TopN) rank(r dbRow, seq int) (rankedRow, error) {
+	v, ok := r[n.By]
+	if !ok {
+		return rankedRow{}, fmt.Errorf("%q not exists in columns", n.By)
+	}
+	rr := rankedRow{row: r, null: v == nil, seq: seq}
+	if v == nil {
+		return rr, nil
+	}
+	mv, err := toMetricValue(v)
+	if err != nil {
+		return rankedRow{}, fmt.Errorf("column %q: %w", n.By, err)
+	}
+	rr.value, _ = toFloat64(mv)
+	return rr, nil
+}
+
+// compareRank returns a negative number if a ranks lower than b. Rows that have NULL rank the lowest.
+func compareRank(a, b rankedRow) int {
+	if a.null || b.null {
+		if c := cmp.Compare(btoi(b.null), btoi(a.null)); c != 0 {
+			return c
+		}
+	} else if c := cmp.Compare(a.value, b.value); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.seq, a.seq)
+}
+
+// topNHeap is a min-heap of rows by rank, so the lowest of the top rows can be replaced.
+type topNHeap []rankedRow
+
+func (h topNHeap) Len() int           { return len(h) }
+func (h topNHeap) Less(i, j int) bool { return compareRank(h[i], h[j]) < 0 }
+func (h topNHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topNHeap) Push(x any)        { *h = append(*h, x.(rankedRow)) }
+func (h *topNHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}